@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+)
+
+// boardFormatVersion is bumped whenever board.bson changes shape. Decoding
+// refuses boards from a newer version than it knows.
+const boardFormatVersion = 1
+
+// board.bson is a BSON document:
+//
+//	{
+//	  "version": int32,
+//	  "size_x":  int32,
+//	  "size_y":  int32,
+//	  "color":   int32 (0xRRGGBBAA),
+//	  "tiles":   [ [ tile, ... ], ... ]  // Tiles[j][i]
+//	}
+//
+// and every tile is
+//
+//	{
+//	  "color":    int32 (0xRRGGBBAA),
+//	  "selected": bool,
+//	  "occupant": unit  // only there when the tile is occupied
+//	}
+//
+// with units written field by field under snake_case names. Only the
+// position is stored: where a board is drawn, which tiles the player has
+// clicked and other view state never make it into the file.
+
+func packColor(c color.RGBA) int32 {
+	return int32(uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A))
+}
+
+func unpackColor(v int) color.RGBA {
+	u := uint32(v)
+	return color.RGBA{R: uint8(u >> 24), G: uint8(u >> 16), B: uint8(u >> 8), A: uint8(u)}
+}
+
+func encodeUnit(u Unit) bsonDoc {
+	return bsonDoc{
+		{"name", u.Name},
+		{"move_range", int32(u.MoveRange)},
+		{"hp", int32(u.HP)},
+		{"starting_hp", int32(u.StartingHP)},
+		{"offense", int32(u.Offense)},
+		{"defense", int32(u.Defense)},
+		{"bot_unit", u.BotUnit},
+		{"faction", u.Faction},
+		{"ability", u.Ability},
+		{"cooldown", int32(u.Cooldown)},
+		{"ap", int32(u.AP)},
+	}
+}
+
+func decodeUnit(d bsonDoc) (Unit, error) {
+	var err error
+	u := Unit{Present: true}
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"move_range", &u.MoveRange},
+		{"hp", &u.HP},
+		{"starting_hp", &u.StartingHP},
+		{"offense", &u.Offense},
+		{"defense", &u.Defense},
+	}
+	for _, f := range ints {
+		if *f.dst, err = d.Int(f.key); err != nil {
+			return Unit{}, err
+		}
+	}
+	if u.Name, err = d.String("name"); err != nil {
+		return Unit{}, err
+	}
+	if u.BotUnit, err = d.Bool("bot_unit"); err != nil {
+		return Unit{}, err
+	}
+	// Boards from before units came from files don't have these
+	if d.Has("faction") {
+		if u.Faction, err = d.String("faction"); err != nil {
+			return Unit{}, err
+		}
+	}
+	if d.Has("ability") {
+		if u.Ability, err = d.String("ability"); err != nil {
+			return Unit{}, err
+		}
+	}
+	if d.Has("cooldown") {
+		if u.Cooldown, err = d.Int("cooldown"); err != nil {
+			return Unit{}, err
+		}
+	}
+	u.AP = unitActionPoints
+	if d.Has("ap") {
+		if u.AP, err = d.Int("ap"); err != nil {
+			return Unit{}, err
+		}
+	}
+	return u, nil
+}
+
+// encodeBoard serializes the position on a board to board.bson.
+func encodeBoard(grid *TileGrid) ([]byte, error) {
+	rows := make([]any, len(grid.Tiles))
+	for j := range grid.Tiles {
+		row := make([]any, len(grid.Tiles[j]))
+		for i, tile := range grid.Tiles[j] {
+			t := bsonDoc{
+				{"color", packColor(tile.Color)},
+				{"selected", tile.Selected},
+			}
+			if tile.Occupant.Present {
+				t = append(t, bsonElem{"occupant", encodeUnit(tile.Occupant)})
+			}
+			row[i] = t
+		}
+		rows[j] = row
+	}
+	return bsonDoc{
+		{"version", int32(boardFormatVersion)},
+		{"size_x", int32(grid.SizeX)},
+		{"size_y", int32(grid.SizeY)},
+		{"color", packColor(grid.Color)},
+		{"tiles", rows},
+	}.Marshal()
+}
+
+// decodeBoard reads a board.bson back into a grid. The grid isn't placed
+// anywhere on screen yet.
+func decodeBoard(data []byte) (TileGrid, error) {
+	doc, err := unmarshalBSON(data)
+	if err != nil {
+		return TileGrid{}, err
+	}
+	version, err := doc.Int("version")
+	if err != nil {
+		return TileGrid{}, fmt.Errorf("board.bson: %w", err)
+	}
+	if version < 1 {
+		return TileGrid{}, fmt.Errorf("board.bson: bad version %d", version)
+	}
+	if version > boardFormatVersion {
+		return TileGrid{}, fmt.Errorf("board.bson: version %d is newer than %d", version, boardFormatVersion)
+	}
+	grid := TileGrid{ClickMap: make(map[string]bool)}
+	if grid.SizeX, err = doc.Int("size_x"); err != nil {
+		return TileGrid{}, fmt.Errorf("board.bson: %w", err)
+	}
+	if grid.SizeY, err = doc.Int("size_y"); err != nil {
+		return TileGrid{}, fmt.Errorf("board.bson: %w", err)
+	}
+	c, err := doc.Int("color")
+	if err != nil {
+		return TileGrid{}, fmt.Errorf("board.bson: %w", err)
+	}
+	grid.Color = unpackColor(c)
+	rows, err := doc.Array("tiles")
+	if err != nil {
+		return TileGrid{}, fmt.Errorf("board.bson: %w", err)
+	}
+	// Boards are square, and the rest of the game walks the tiles taking
+	// that for granted
+	if grid.SizeX < 1 || grid.SizeX != grid.SizeY {
+		return TileGrid{}, fmt.Errorf("board.bson: bad size %dx%d", grid.SizeX, grid.SizeY)
+	}
+	if len(rows) != grid.SizeX {
+		return TileGrid{}, fmt.Errorf("board.bson: %d rows, size_x is %d", len(rows), grid.SizeX)
+	}
+	grid.Tiles = make([][]*Tile, len(rows))
+	for j, r := range rows {
+		row, ok := r.([]any)
+		if !ok {
+			return TileGrid{}, fmt.Errorf("board.bson: row %d is a %T", j, r)
+		}
+		if len(row) != grid.SizeY {
+			return TileGrid{}, fmt.Errorf("board.bson: row %d has %d tiles, size_y is %d", j, len(row), grid.SizeY)
+		}
+		grid.Tiles[j] = make([]*Tile, len(row))
+		for i, v := range row {
+			t, ok := v.(bsonDoc)
+			if !ok {
+				return TileGrid{}, fmt.Errorf("board.bson: tile %d,%d is a %T", j, i, v)
+			}
+			tile := &Tile{}
+			c, err := t.Int("color")
+			if err != nil {
+				return TileGrid{}, fmt.Errorf("board.bson: tile %d,%d: %w", j, i, err)
+			}
+			tile.Color = unpackColor(c)
+			if tile.Selected, err = t.Bool("selected"); err != nil {
+				return TileGrid{}, fmt.Errorf("board.bson: tile %d,%d: %w", j, i, err)
+			}
+			if t.Has("occupant") {
+				u, err := t.Doc("occupant")
+				if err != nil {
+					return TileGrid{}, fmt.Errorf("board.bson: tile %d,%d: %w", j, i, err)
+				}
+				if tile.Occupant, err = decodeUnit(u); err != nil {
+					return TileGrid{}, fmt.Errorf("board.bson: tile %d,%d: %w", j, i, err)
+				}
+			}
+			grid.Tiles[j][i] = tile
+		}
+	}
+	return grid, nil
+}
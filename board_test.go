@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestBoardRoundTrip(t *testing.T) {
+	player := Unit{
+		Name:       "Newt-Hands",
+		MoveRange:  3,
+		HP:         4,
+		StartingHP: 5,
+		Offense:    3,
+		Defense:    2,
+		Faction:    "committers",
+		Ability:    "regenerate",
+		Cooldown:   1,
+		AP:         2,
+		Present:    true,
+	}
+	bot := Unit{
+		Name:       "WIZZY",
+		MoveRange:  10,
+		HP:         1,
+		StartingHP: 1,
+		Offense:    8,
+		Defense:    1,
+		BotUnit:    true,
+		Faction:    "wizards",
+		Ability:    "sonic_blast",
+		Cooldown:   3,
+		// Out of points, which has to stay 0 rather than come back full
+		AP:      0,
+		Present: true,
+	}
+	tests := []struct {
+		name  string
+		board func() TileGrid
+	}{
+		{"empty", func() TileGrid {
+			return createGrid(0, 0, 3, 3, 120, 120, color.RGBA{R: 255, G: 255, B: 255, A: 200})
+		}},
+		{"units", func() TileGrid {
+			grid := createGrid(0, 0, 4, 4, 160, 160, color.RGBA{R: 1, G: 2, B: 3, A: 4})
+			grid.Tiles[0][1].Occupant = player
+			grid.Tiles[3][2].Occupant = bot
+			return grid
+		}},
+		{"selected and coloured tiles", func() TileGrid {
+			grid := createGrid(0, 0, 2, 2, 80, 80, color.RGBA{})
+			grid.Tiles[1][0].Selected = true
+			grid.Tiles[1][1].Color = color.RGBA{R: 0xde, G: 0xad, B: 0xbe, A: 0xef}
+			grid.Tiles[1][1].Occupant = bot
+			return grid
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grid := tt.board()
+			data, err := encodeBoard(&grid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeBoard(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := encodeBoard(&decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, again) {
+				t.Error("re-encoding the decoded board gives different bytes")
+			}
+			if decoded.SizeX != grid.SizeX || decoded.SizeY != grid.SizeY || decoded.Color != grid.Color {
+				t.Errorf("board is %dx%d %v, want %dx%d %v", decoded.SizeX, decoded.SizeY, decoded.Color, grid.SizeX, grid.SizeY, grid.Color)
+			}
+			for j := range grid.Tiles {
+				for i, want := range grid.Tiles[j] {
+					if got := decoded.Tiles[j][i]; *got != *want {
+						t.Errorf("tile %d,%d is %+v, want %+v", j, i, *got, *want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// testBoardDoc is a valid 2x2 board.bson, to break in different ways.
+func testBoardDoc() bsonDoc {
+	tile := func() bsonDoc {
+		return bsonDoc{{"color", int32(0)}, {"selected", false}}
+	}
+	return bsonDoc{
+		{"version", int32(boardFormatVersion)},
+		{"size_x", int32(2)},
+		{"size_y", int32(2)},
+		{"color", int32(0)},
+		{"tiles", []any{[]any{tile(), tile()}, []any{tile(), tile()}}},
+	}
+}
+
+func setKey(d bsonDoc, key string, v any) bsonDoc {
+	for k := range d {
+		if d[k].Key == key {
+			d[k].Value = v
+		}
+	}
+	return d
+}
+
+func TestDecodeBoardRejects(t *testing.T) {
+	tile := bsonDoc{{"color", int32(0)}, {"selected", false}}
+	tests := []struct {
+		name string
+		doc  bsonDoc
+		err  string
+	}{
+		{"version 0", setKey(testBoardDoc(), "version", int32(0)), "bad version"},
+		{"negative version", setKey(testBoardDoc(), "version", int32(-1)), "bad version"},
+		{"newer version", setKey(testBoardDoc(), "version", int32(boardFormatVersion+1)), "newer"},
+		{"not square", setKey(testBoardDoc(), "size_y", int32(3)), "bad size"},
+		{"no size", setKey(setKey(testBoardDoc(), "size_x", int32(0)), "size_y", int32(0)), "bad size"},
+		{"too few rows", setKey(testBoardDoc(), "tiles", []any{[]any{tile, tile}}), "1 rows"},
+		{"ragged rows", setKey(testBoardDoc(), "tiles", []any{[]any{tile, tile}, []any{tile}}), "row 1 has 1 tiles"},
+		{"row isn't an array", setKey(testBoardDoc(), "tiles", []any{[]any{tile, tile}, "row"}), "row 1 is a"},
+		{"tile isn't a document", setKey(testBoardDoc(), "tiles", []any{[]any{tile, tile}, []any{tile, int32(1)}}), "tile 1,1 is a"},
+		{"occupant isn't a document", setKey(testBoardDoc(), "tiles", []any{
+			[]any{tile, tile},
+			[]any{tile, append(bsonDoc{{"occupant", "WIZZY"}}, tile...)},
+		}), "tile 1,1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.doc.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = decodeBoard(data)
+			if err == nil {
+				t.Fatal("decoded a broken board")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %q doesn't mention %q", err, tt.err)
+			}
+		})
+	}
+}
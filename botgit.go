@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gitjits/molniya/engine"
+)
+
+// enemySays echoes a git command the enemy runs.
+func enemySays(g *Game, command string) {
+	g.logger.AddMessage(authorEnemy+"$ ", command, false)
+}
+
+// enemyCommit commits the board on the table as the enemy.
+func enemyCommit(g *Game, message string) bool {
+	tree := &g.gridTree
+	node, err := tree.commit(*g.selected, []string{tree.headHash()}, authorEnemy, message)
+	if err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return false
+	}
+	enemySays(g, fmt.Sprintf("git commit -am '%s'", message))
+	g.logger.AddMessage("", fmt.Sprintf("[%s %s] %s", tree.branchLabel(), node.hash[0:8], message), true)
+	g.autoScroll = true
+	return true
+}
+
+// nextEnemyBranch picks the lowest free enemyN name.
+func nextEnemyBranch(t *GridTree) string {
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("enemy%d", n)
+		if _, ok := t.refs[name]; !ok {
+			return name
+		}
+	}
+}
+
+// wantsToGamble reports whether the bot should play its action on a branch:
+// it's a fight the dice could still turn against it, and the bot has
+// gambles left and room in the repository to make one.
+func wantsToGamble(g *Game, a engine.Action) bool {
+	s := g.selected.State()
+	if a.Ability || !s.At(a.To).Present {
+		return false
+	}
+	if g.botGambles <= 0 || g.merge != nil || g.gridTree.head == "" || branchLimitReached(g) {
+		return false
+	}
+	return !engine.Predict(s.Inspired(a.From), s.Inspired(a.To)).Overwhelming
+}
+
+// enemyGamble has the bot try a risky attack on a branch of its own, the same
+// way the player can. A fight it wins gets merged back in. A fight it loses is
+// thrown away along with the branch, which uses up a gamble and the rest of
+// the bot's turn. It reports whether the attack stuck.
+func enemyGamble(g *Game, a engine.Action) bool {
+	tree := &g.gridTree
+	base := tree.head
+	if workingTreeDirty(g) && !enemyCommit(g, "checkpoint") {
+		return false
+	}
+	name := nextEnemyBranch(tree)
+	if err := tree.createBranch(name); err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return false
+	}
+	tree.checkout(name)
+	enemySays(g, "git checkout -b "+name)
+
+	g.selected.selectedCells[0] = fromPos(a.From)
+	g.selected.selectedCells[1] = fromPos(a.To)
+	g.aiming = false
+	events := g.selected.applyMove(g)
+	fight, ok := engine.Attacked{}, false
+	if len(events) > 0 {
+		fight, ok = events[0].(engine.Attacked)
+	}
+	if !ok {
+		// The rules didn't have it, forget the branch ever happened
+		tree.checkout(base)
+		tree.deleteBranch(name)
+		setWorkingGrid(g, tree.headCommit().grid)
+		return false
+	}
+	enemyCommit(g, fmt.Sprintf("%s attacks %s", fight.Attacker.Name, fight.Defender.Name))
+	tip := tree.headHash()
+
+	enemySays(g, "git checkout "+base)
+	tree.checkout(base)
+	if fight.AttackerWonExchange {
+		enemySays(g, "git merge "+name)
+		g.logger.AddMessage("", fmt.Sprintf("Updating %s..%s", tree.headHash()[0:7], tip[0:7]), true)
+		g.logger.AddMessage("", "Fast-forward", true)
+		tree.moveHead(tip)
+		enemySays(g, "git branch -d "+name)
+	} else {
+		g.botGambles--
+		enemySays(g, "git branch -D "+name)
+		g.logger.AddMessage("[!] ", "The enemy didn't like how that went and rewrote history", false)
+	}
+	g.logger.AddMessage("", fmt.Sprintf("Deleted branch %s (was %s).", name, tip[0:7]), true)
+	tree.deleteBranch(name)
+	setWorkingGrid(g, tree.headCommit().grid)
+	g.autoScroll = true
+	return fight.AttackerWonExchange
+}
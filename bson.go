@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// This is the subset of BSON (https://bsonspec.org) the game writes:
+// int32, int64, double, string, bool, binary, embedded documents and arrays.
+// Documents keep their key order so that encoding is deterministic, which
+// the object store relies on for hashing.
+
+const (
+	bsonDouble   byte = 0x01
+	bsonString   byte = 0x02
+	bsonDocument byte = 0x03
+	bsonArray    byte = 0x04
+	bsonBinary   byte = 0x05
+	bsonBool     byte = 0x08
+	bsonInt32    byte = 0x10
+	bsonInt64    byte = 0x12
+)
+
+type bsonElem struct {
+	Key   string
+	Value any
+}
+
+// bsonDoc is an ordered BSON document. Values are int32, int64, float64,
+// string, bool, []byte, bsonDoc or []any.
+type bsonDoc []bsonElem
+
+func (d bsonDoc) Marshal() ([]byte, error) {
+	var body bytes.Buffer
+	for _, e := range d {
+		if err := writeBSONElem(&body, e.Key, e.Value); err != nil {
+			return nil, err
+		}
+	}
+	out := binary.LittleEndian.AppendUint32(nil, uint32(body.Len()+5))
+	out = append(out, body.Bytes()...)
+	return append(out, 0), nil
+}
+
+func writeBSONElem(w *bytes.Buffer, key string, v any) error {
+	le := binary.LittleEndian
+	var kind byte
+	var payload []byte
+	switch v := v.(type) {
+	case int32:
+		kind, payload = bsonInt32, le.AppendUint32(nil, uint32(v))
+	case int64:
+		kind, payload = bsonInt64, le.AppendUint64(nil, uint64(v))
+	case float64:
+		kind, payload = bsonDouble, le.AppendUint64(nil, math.Float64bits(v))
+	case string:
+		kind = bsonString
+		payload = le.AppendUint32(nil, uint32(len(v)+1))
+		payload = append(append(payload, v...), 0)
+	case bool:
+		kind, payload = bsonBool, []byte{0}
+		if v {
+			payload[0] = 1
+		}
+	case []byte:
+		kind = bsonBinary
+		payload = le.AppendUint32(nil, uint32(len(v)))
+		payload = append(append(payload, 0), v...)
+	case bsonDoc:
+		sub, err := v.Marshal()
+		if err != nil {
+			return err
+		}
+		kind, payload = bsonDocument, sub
+	case []any:
+		arr := make(bsonDoc, len(v))
+		for i, item := range v {
+			arr[i] = bsonElem{Key: strconv.Itoa(i), Value: item}
+		}
+		sub, err := arr.Marshal()
+		if err != nil {
+			return err
+		}
+		kind, payload = bsonArray, sub
+	default:
+		return fmt.Errorf("bson: can't encode %T under %q", v, key)
+	}
+	w.WriteByte(kind)
+	w.WriteString(key)
+	w.WriteByte(0)
+	w.Write(payload)
+	return nil
+}
+
+func unmarshalBSON(data []byte) (bsonDoc, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("bson: document too short")
+	}
+	size := int(binary.LittleEndian.Uint32(data))
+	if size > len(data) || size < 5 || data[size-1] != 0 {
+		return nil, fmt.Errorf("bson: bad document length %d", size)
+	}
+	body := data[4 : size-1]
+	var doc bsonDoc
+	for len(body) > 0 {
+		kind := body[0]
+		nul := bytes.IndexByte(body[1:], 0)
+		if nul < 0 {
+			return nil, fmt.Errorf("bson: unterminated key")
+		}
+		key := string(body[1 : 1+nul])
+		body = body[2+nul:]
+		v, n, err := readBSONValue(kind, body)
+		if err != nil {
+			return nil, fmt.Errorf("bson: %s: %w", key, err)
+		}
+		doc = append(doc, bsonElem{Key: key, Value: v})
+		body = body[n:]
+	}
+	return doc, nil
+}
+
+func readBSONValue(kind byte, b []byte) (any, int, error) {
+	le := binary.LittleEndian
+	need := func(n int) error {
+		if len(b) < n {
+			return fmt.Errorf("truncated value")
+		}
+		return nil
+	}
+	switch kind {
+	case bsonInt32:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		return int32(le.Uint32(b)), 4, nil
+	case bsonInt64:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return int64(le.Uint64(b)), 8, nil
+	case bsonDouble:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(le.Uint64(b)), 8, nil
+	case bsonBool:
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return b[0] != 0, 1, nil
+	case bsonString:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		n := int(le.Uint32(b))
+		if n < 1 || len(b) < 4+n {
+			return nil, 0, fmt.Errorf("truncated string")
+		}
+		return string(b[4 : 4+n-1]), 4 + n, nil
+	case bsonBinary:
+		if err := need(5); err != nil {
+			return nil, 0, err
+		}
+		n := int(le.Uint32(b))
+		if len(b) < 5+n {
+			return nil, 0, fmt.Errorf("truncated binary")
+		}
+		return append([]byte(nil), b[5:5+n]...), 5 + n, nil
+	case bsonDocument, bsonArray:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		n := int(le.Uint32(b))
+		sub, err := unmarshalBSON(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		if kind == bsonDocument {
+			return sub, n, nil
+		}
+		arr := make([]any, len(sub))
+		for i, e := range sub {
+			arr[i] = e.Value
+		}
+		return arr, n, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported element type 0x%02x", kind)
+}
+
+func (d bsonDoc) lookup(key string) (any, bool) {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (d bsonDoc) Int(key string) (int, error) {
+	v, ok := d.lookup(key)
+	switch v := v.(type) {
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	}
+	if !ok {
+		return 0, fmt.Errorf("missing %q", key)
+	}
+	return 0, fmt.Errorf("%q is a %T, not an integer", key, v)
+}
+
+func (d bsonDoc) Int64(key string) (int64, error) {
+	v, ok := d.lookup(key)
+	switch v := v.(type) {
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	}
+	if !ok {
+		return 0, fmt.Errorf("missing %q", key)
+	}
+	return 0, fmt.Errorf("%q is a %T, not an integer", key, v)
+}
+
+func (d bsonDoc) String(key string) (string, error) {
+	v, ok := d.lookup(key)
+	s, isStr := v.(string)
+	if !ok {
+		return "", fmt.Errorf("missing %q", key)
+	} else if !isStr {
+		return "", fmt.Errorf("%q is a %T, not a string", key, v)
+	}
+	return s, nil
+}
+
+func (d bsonDoc) Bool(key string) (bool, error) {
+	v, ok := d.lookup(key)
+	b, isBool := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("missing %q", key)
+	} else if !isBool {
+		return false, fmt.Errorf("%q is a %T, not a bool", key, v)
+	}
+	return b, nil
+}
+
+func (d bsonDoc) Binary(key string) ([]byte, error) {
+	v, ok := d.lookup(key)
+	b, isBin := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("missing %q", key)
+	} else if !isBin {
+		return nil, fmt.Errorf("%q is a %T, not binary", key, v)
+	}
+	return b, nil
+}
+
+func (d bsonDoc) Doc(key string) (bsonDoc, error) {
+	v, ok := d.lookup(key)
+	sub, isDoc := v.(bsonDoc)
+	if !ok {
+		return nil, fmt.Errorf("missing %q", key)
+	} else if !isDoc {
+		return nil, fmt.Errorf("%q is a %T, not a document", key, v)
+	}
+	return sub, nil
+}
+
+func (d bsonDoc) Array(key string) ([]any, error) {
+	v, ok := d.lookup(key)
+	arr, isArr := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("missing %q", key)
+	} else if !isArr {
+		return nil, fmt.Errorf("%q is a %T, not an array", key, v)
+	}
+	return arr, nil
+}
+
+// Has reports whether the document has a key at all.
+func (d bsonDoc) Has(key string) bool {
+	_, ok := d.lookup(key)
+	return ok
+}
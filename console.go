@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// Console is the prompt on the last line of the log, where git commands can
+// be typed instead of using the single key shortcuts. Enter starts typing,
+// esc stops.
+type Console struct {
+	focused bool
+	input   []rune
+	history []string
+	// Position in history while browsing it with up and down, len(history)
+	// when not browsing
+	browse int
+}
+
+// Frames a key has to be held before it starts repeating, and how often it
+// repeats after that
+const (
+	keyRepeatDelay = 30
+	keyRepeatEvery = 3
+)
+
+func repeating(key ebiten.Key) bool {
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || d >= keyRepeatDelay && d%keyRepeatEvery == 0
+}
+
+func (c *Console) Update(g *Game) {
+	if !c.focused {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+			c.focused = true
+			c.browse = len(c.history)
+		}
+		return
+	}
+	for _, r := range ebiten.AppendInputChars(nil) {
+		c.input = append(c.input, r)
+	}
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyEscape):
+		c.focused = false
+	case inpututil.IsKeyJustPressed(ebiten.KeyEnter):
+		line := strings.TrimSpace(string(c.input))
+		c.input = c.input[:0]
+		if line != "" {
+			if len(c.history) == 0 || c.history[len(c.history)-1] != line {
+				c.history = append(c.history, line)
+			}
+			runCommand(g, line)
+		}
+		c.browse = len(c.history)
+	case repeating(ebiten.KeyBackspace):
+		if len(c.input) > 0 {
+			c.input = c.input[:len(c.input)-1]
+		}
+	case inpututil.IsKeyJustPressed(ebiten.KeyTab):
+		c.complete(g)
+	case inpututil.IsKeyJustPressed(ebiten.KeyArrowUp):
+		if c.browse > 0 {
+			c.browse--
+			c.input = []rune(c.history[c.browse])
+		}
+	case inpututil.IsKeyJustPressed(ebiten.KeyArrowDown):
+		if c.browse < len(c.history)-1 {
+			c.browse++
+			c.input = []rune(c.history[c.browse])
+		} else {
+			c.browse = len(c.history)
+			c.input = c.input[:0]
+		}
+	}
+}
+
+// Draw puts the prompt on the last line of the log window.
+func (c *Console) Draw(screen *ebiten.Image, l *LogWindow) {
+	lines := int(l.height/l.lineHeight) - 1
+	y := screenHeight - int(l.height) + lines*int(l.lineHeight)
+	line := "you$ "
+	col := color.RGBA{0, 255, 50, 120}
+	if c.focused {
+		line += string(c.input)
+		if l.status/30%2 == 0 {
+			line += "_"
+		}
+		col.A = 255
+	} else {
+		line += "(enter to type a git command)"
+	}
+	text.Draw(screen, line, l.font, 15, y+15, col)
+}
+
+// complete finishes the word being typed: git, then a subcommand, then a
+// branch or commit. With several candidates it goes as far as they agree
+// and lists them.
+func (c *Console) complete(g *Game) {
+	line := string(c.input)
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+	var pool []string
+	switch len(strings.Fields(line[:start])) {
+	case 0:
+		pool = []string{"git"}
+	case 1:
+		pool = gitCommandNames()
+	default:
+		pool = append(g.gridTree.branches(), "HEAD")
+		if word != "" {
+			for _, node := range g.gridTree.order {
+				pool = append(pool, node.hash[0:7])
+			}
+		}
+	}
+	var matches []string
+	for _, cand := range pool {
+		if strings.HasPrefix(cand, word) {
+			matches = append(matches, cand)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return
+	case 1:
+		c.input = []rune(line[:start] + matches[0] + " ")
+		return
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) > len(word) {
+		c.input = []rune(line[:start] + prefix)
+		return
+	}
+	g.logger.AddMessage("", strings.Join(matches, "  "), true)
+}
+
+// splitArgs breaks a command line into words the way a shell would, with
+// single and double quotes holding spaces.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unexpected EOF while looking for matching `%c'", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// gitCommand is one of the git subcommands the console understands.
+type gitCommand struct {
+	usage string
+	run   func(g *Game, args []string)
+}
+
+var gitCommands map[string]gitCommand
+
+func init() {
+	gitCommands = map[string]gitCommand{
+		"commit":   {"git commit -m <message>", consoleCommit},
+		"checkout": {"git checkout [-b <new-branch>] <branch|commit>", consoleCheckout},
+		"merge":    {"git merge <branch> | --abort", consoleMerge},
+		"reset":    {"git reset --hard [<commit>]", consoleReset},
+		"status":   {"git status", consoleStatus},
+		"log":      {"git log [--oneline] [--all] [-n <number>]", consoleLog},
+		"branch":   {"git branch [<name> | -m [<old>] <new> | -d <name> | -D <name>]", consoleBranch},
+		"help":     {"git help", consoleHelp},
+	}
+}
+
+func gitCommandNames() []string {
+	return sortedKeys(gitCommands)
+}
+
+// runCommand echoes a typed line into the log and runs it.
+func runCommand(g *Game, line string) {
+	g.logger.AddMessage("you$ ", line, true)
+	args, err := splitArgs(line)
+	if err != nil {
+		g.logger.AddMessage("", "bash: "+err.Error(), true)
+		return
+	}
+	if len(args) == 0 {
+		return
+	}
+	if args[0] != "git" {
+		g.logger.AddMessage("", fmt.Sprintf("bash: %s: command not found", args[0]), true)
+		return
+	}
+	if len(args) == 1 || args[1] == "--help" {
+		consoleHelp(g, nil)
+		return
+	}
+	cmd, ok := gitCommands[args[1]]
+	if !ok {
+		g.logger.AddMessage("", fmt.Sprintf("git: '%s' is not a git command. See 'git --help'.", args[1]), true)
+		return
+	}
+	cmd.run(g, args[2:])
+}
+
+func usage(g *Game, name string) {
+	g.logger.AddMessage("", "usage: "+gitCommands[name].usage, true)
+}
+
+func consoleHelp(g *Game, args []string) {
+	g.logger.AddMessage("", "usage: git <command> [<args>]", true)
+	for _, name := range gitCommandNames() {
+		g.logger.AddMessage("", "   "+gitCommands[name].usage, true)
+	}
+}
+
+func consoleCommit(g *Game, args []string) {
+	message := ""
+	for k := 0; k < len(args); k++ {
+		switch arg := args[k]; {
+		case arg == "-m" || arg == "--message":
+			if k+1 == len(args) {
+				g.logger.AddMessage("", "error: switch `m' requires a value", true)
+				return
+			}
+			k++
+			message = args[k]
+		case strings.HasPrefix(arg, "--message="):
+			message = strings.TrimPrefix(arg, "--message=")
+		case strings.HasPrefix(arg, "-m"):
+			message = strings.TrimPrefix(arg, "-m")
+		default:
+			g.logger.AddMessage("", fmt.Sprintf("error: unknown option `%s'", arg), true)
+			usage(g, "commit")
+			return
+		}
+	}
+	if strings.TrimSpace(message) == "" {
+		g.logger.AddMessage("", "Aborting commit due to empty commit message.", true)
+		return
+	}
+	if g.merge == nil && !workingTreeDirty(g) {
+		g.logger.AddMessage("", "nothing to commit, working tree clean", true)
+		return
+	}
+	commitBoard(g, *g.selected, message, true)
+}
+
+// validBranchName follows git check-ref-format for a branch name, and on top
+// of that turns away anything that reads like a revision instead.
+func validBranchName(name string) bool {
+	if name == "" || name == "HEAD" || strings.HasPrefix(name, "-") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.ContainsAny(name, " ~^:?*[\\@") {
+		return false
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+	// No empty components, so no leading, trailing or doubled slashes, and
+	// none that git would take for a hidden or lock file
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
+func consoleCheckout(g *Game, args []string) {
+	switch {
+	case len(args) == 2 && args[0] == "-b":
+		name := args[1]
+		if checkoutNewBranch(g, name) {
+			g.logger.AddMessage("", "Switched to a new branch '"+name+"'", true)
+		}
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		checkoutRev(g, args[0])
+	default:
+		usage(g, "checkout")
+	}
+}
+
+func consoleMerge(g *Game, args []string) {
+	switch {
+	case len(args) == 1 && args[0] == "--abort":
+		if g.merge == nil {
+			g.logger.AddMessage("", "fatal: There is no merge to abort (MERGE_HEAD missing).", true)
+			return
+		}
+		abortMerge(g)
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		mergeBranch(g, args[0], false)
+	default:
+		usage(g, "merge")
+	}
+}
+
+func consoleReset(g *Game, args []string) {
+	if len(args) == 0 || args[0] != "--hard" || len(args) > 2 {
+		g.logger.AddMessage("[!] ", "The board is the only file, so only --hard resets make sense", true)
+		usage(g, "reset")
+		return
+	}
+	rev := "HEAD"
+	if len(args) == 2 {
+		rev = args[1]
+	}
+	hash, err := g.gridTree.resolve(rev)
+	if err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	resetHard(g, hash)
+}
+
+func consoleStatus(g *Game, args []string) {
+	tree := &g.gridTree
+	if tree.head == "" {
+		g.logger.AddMessage("", "HEAD detached at "+tree.detached[0:7], true)
+	} else {
+		g.logger.AddMessage("", "On branch "+tree.head, true)
+	}
+	switch {
+	case g.merge != nil:
+		g.logger.AddMessage("", "You have unmerged paths.", true)
+		logUnresolved(g)
+	case workingTreeDirty(g):
+		g.logger.AddMessage("", "Changes not staged for commit:", true)
+		g.logger.AddMessage("", "        modified:   board.bson", true)
+	default:
+		g.logger.AddMessage("", "nothing to commit, working tree clean", true)
+	}
+}
+
+func consoleLog(g *Game, args []string) {
+	oneline, all, limit := false, false, 0
+	for k := 0; k < len(args); k++ {
+		arg := args[k]
+		count := ""
+		switch {
+		case arg == "--oneline":
+			oneline = true
+		case arg == "--all":
+			all = true
+		case arg == "--graph":
+			// Always on
+		case arg == "-n" || arg == "--max-count":
+			if k+1 == len(args) {
+				g.logger.AddMessage("", "error: switch `n' requires a value", true)
+				return
+			}
+			k++
+			count = args[k]
+		case strings.HasPrefix(arg, "--max-count="):
+			count = strings.TrimPrefix(arg, "--max-count=")
+		case strings.HasPrefix(arg, "-n"):
+			count = strings.TrimPrefix(arg, "-n")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && strings.Trim(arg[1:], "0123456789") == "":
+			count = arg[1:]
+		default:
+			g.logger.AddMessage("", fmt.Sprintf("fatal: unrecognized argument: %s", arg), true)
+			usage(g, "log")
+			return
+		}
+		if count != "" {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				g.logger.AddMessage("", fmt.Sprintf("fatal: '%s': not an integer", count), true)
+				return
+			}
+			limit = n
+			if n == 0 {
+				// git log -n 0 shows nothing
+				return
+			}
+		}
+	}
+	tips := []string{g.gridTree.headHash()}
+	if all {
+		for _, name := range g.gridTree.branches() {
+			tips = append(tips, g.gridTree.refs[name])
+		}
+	}
+	for _, line := range g.gridTree.logGraph(tips, oneline, limit) {
+		g.logger.AddMessage("", line, true)
+	}
+}
+
+func consoleBranch(g *Game, args []string) {
+	tree := &g.gridTree
+	switch {
+	case len(args) == 0 || len(args) == 1 && (args[0] == "--list" || args[0] == "-l"):
+		if tree.head == "" {
+			g.logger.AddMessage("", "* (HEAD detached at "+tree.detached[0:7]+")", true)
+		}
+		for _, name := range tree.branches() {
+			mark := "  "
+			if name == tree.head {
+				mark = "* "
+			}
+			g.logger.AddMessage("", mark+name, true)
+		}
+		if g.rules.maxBranches > 0 {
+			g.logger.AddMessage("[!] ", fmt.Sprintf("%d of %d branches in use", len(tree.refs), g.rules.maxBranches), true)
+		}
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		startBranch(g, args[0])
+	case (args[0] == "-m" || args[0] == "-M") && (len(args) == 2 || len(args) == 3):
+		old, name := tree.head, args[len(args)-1]
+		if len(args) == 3 {
+			old = args[1]
+		} else if old == "" {
+			g.logger.AddMessage("", "fatal: cannot rename the current branch while not on any branch.", true)
+			return
+		}
+		renameBranch(g, old, name)
+	case (args[0] == "-d" || args[0] == "-D" || args[0] == "--delete") && len(args) == 2:
+		deleteBranch(g, args[1], args[0] == "-D")
+	default:
+		usage(g, "branch")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"   ", nil, false},
+		{"git status", []string{"git", "status"}, false},
+		{"  git \t log  ", []string{"git", "log"}, false},
+		{`git commit -m "two words"`, []string{"git", "commit", "-m", "two words"}, false},
+		{`git commit -m 'it''s'`, []string{"git", "commit", "-m", "its"}, false},
+		{`git commit -m "it's"`, []string{"git", "commit", "-m", "it's"}, false},
+		{`a"b c"d`, []string{"ab cd"}, false},
+		{`"" x`, []string{"", "x"}, false},
+		{`git commit -m "unfinished`, nil, true},
+		{`'`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) error = %v, want error %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestValidBranchName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"main", true},
+		{"feature/board", true},
+		{"fix-42", true},
+		{"v1.2", true},
+		{"", false},
+		{"HEAD", false},
+		{"-b", false},
+		{"trailing.", false},
+		{"a..b", false},
+		{"has space", false},
+		{"tilde~1", false},
+		{"caret^", false},
+		{"colon:", false},
+		{"what?", false},
+		{"star*", false},
+		{"open[", false},
+		{`back\slash`, false},
+		{"at@", false},
+		{"tab\there", false},
+		{"bell\a", false},
+		{"del\x7f", false},
+		{"/leading", false},
+		{"trailing/", false},
+		{"double//slash", false},
+		{"branch.lock", false},
+		{"dir.lock/name", false},
+		{".hidden", false},
+		{"dir/.hidden", false},
+	}
+	for _, tt := range tests {
+		if got := validBranchName(tt.name); got != tt.want {
+			t.Errorf("validBranchName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
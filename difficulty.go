@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// difficulty is how hard the bot tries.
+type difficulty struct {
+	name string
+	// How many moves ahead the bot looks, time allowing
+	depth int
+	// Chance of the bot playing any old move instead of its best one
+	blunder float64
+	// Frames the bot waits before answering the player
+	reaction int
+	// Lost fights the bot can take back with git
+	gambles int
+}
+
+var difficulties = []difficulty{
+	{name: "easy", depth: 1, blunder: 0.3, reaction: 180, gambles: 0},
+	{name: "normal", depth: 2, blunder: 0.1, reaction: 100, gambles: 1},
+	{name: "hard", depth: 4, blunder: 0, reaction: 40, gambles: 3},
+}
+
+func findDifficulty(name string) (difficulty, error) {
+	var names []string
+	for _, d := range difficulties {
+		if d.name == name {
+			return d, nil
+		}
+		names = append(names, d.name)
+	}
+	return difficulty{}, fmt.Errorf("unknown difficulty %q, pick one of %s", name, strings.Join(names, ", "))
+}
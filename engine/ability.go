@@ -0,0 +1,203 @@
+package engine
+
+import "fmt"
+
+// Targeting is what a unit has to pick to use its ability.
+type Targeting int
+
+const (
+	// Passive abilities are always on and never used by hand.
+	Passive Targeting = iota
+	// Self abilities act on the unit using them.
+	Self
+	// Area abilities hit a tile within Range and everything within Radius
+	// of it.
+	Area
+)
+
+// Ability is a special power a kind of unit has on top of moving and
+// attacking. Cooldown counts the side's own moves until it can be used
+// again.
+type Ability struct {
+	Name        string
+	Description string
+	Targeting   Targeting
+	Range       int
+	Radius      int
+	Cooldown    int
+	Power       int
+}
+
+// Names of the abilities units can have, as unit files spell them.
+const (
+	SonicBlast = "sonic_blast"
+	Regenerate = "regenerate"
+	Inspire    = "inspire"
+	Flight     = "flight"
+)
+
+var abilities = map[string]Ability{
+	SonicBlast: {Name: SonicBlast, Description: "blasts every enemy on and around a tile", Targeting: Area, Range: 2, Radius: 1, Cooldown: 3, Power: 2},
+	Regenerate: {Name: Regenerate, Description: "grows back lost HP", Targeting: Self, Cooldown: 2, Power: 3},
+	Inspire:    {Name: Inspire, Description: "neighbouring allies fight with +1 offense and defense", Targeting: Passive, Power: 1},
+	Flight:     {Name: Flight, Description: "flies over other units", Targeting: Passive},
+}
+
+// LookupAbility finds an ability by name.
+func LookupAbility(name string) (Ability, bool) {
+	a, ok := abilities[name]
+	return a, ok
+}
+
+// Active reports whether the unit has an ability it can use by hand, and
+// which.
+func (u Unit) Active() (Ability, bool) {
+	a, ok := abilities[u.Ability]
+	return a, ok && a.Targeting != Passive
+}
+
+func (u Unit) has(ability string) bool {
+	return u.Present && u.Ability == ability
+}
+
+// Inspired is the unit on p as it fights, with the bonus of any inspiring
+// ally next to it.
+func (s State) Inspired(p Pos) Unit {
+	u := s.At(p)
+	if !u.Present {
+		return u
+	}
+	for _, n := range s.Neighbours(p) {
+		if ally := s.At(n); ally.has(Inspire) && ally.BotUnit == u.BotUnit {
+			bonus := abilities[Inspire].Power
+			u.Offense += bonus
+			u.Defense += bonus
+			break
+		}
+	}
+	return u
+}
+
+// Cast is a unit using its ability on a tile. Self abilities target the
+// unit's own tile.
+type Cast struct {
+	From   Pos
+	Target Pos
+}
+
+// AbilityUsed is a unit using its ability.
+type AbilityUsed struct {
+	Unit    Unit
+	Ability Ability
+	From    Pos
+	Target  Pos
+}
+
+// Damaged is a unit hurt by an ability. Unit is how it came out of it.
+type Damaged struct {
+	Unit   Unit
+	At     Pos
+	Damage int
+	Died   bool
+}
+
+// Healed is a unit getting HP back.
+type Healed struct {
+	Unit   Unit
+	At     Pos
+	Amount int
+}
+
+func (AbilityUsed) isEvent() {}
+func (Damaged) isEvent()     {}
+func (Healed) isEvent()      {}
+
+// CastTargets lists every tile the unit on from could use its ability on
+// right now.
+func (s State) CastTargets(from Pos) []Pos {
+	u := s.At(from)
+	a, ok := u.Active()
+	if !ok || u.Cooldown > 0 {
+		return nil
+	}
+	if a.Targeting == Self {
+		return []Pos{from}
+	}
+	return s.Within(from, a.Range)
+}
+
+// Blast lists the tiles an Area ability aimed at target hits.
+func (s State) Blast(a Ability, target Pos) []Pos {
+	if a.Targeting != Area {
+		return []Pos{target}
+	}
+	return s.Within(target, a.Radius)
+}
+
+// ApplyAbility uses the ability of the unit on c.From and returns the
+// resulting State along with what happened. Like a move, it's the side's
+// whole go. s itself is never modified.
+func ApplyAbility(s State, c Cast) (State, []Event) {
+	reject := func(reason string) (State, []Event) {
+		return s, []Event{Rejected{Move: Move{From: c.From, To: c.Target}, Reason: reason}}
+	}
+	if !s.InBounds(c.From) || !s.InBounds(c.Target) {
+		return reject("that tile isn't on the board")
+	}
+	u := s.At(c.From)
+	if !u.Present {
+		return reject("there's no one there to use an ability")
+	}
+	a, ok := u.Active()
+	if !ok {
+		return reject(fmt.Sprintf("%s has no ability to use", u.Name))
+	}
+	if u.Cooldown > 0 {
+		return reject(fmt.Sprintf("%s's %s is ready in %d moves", u.Name, a.Name, u.Cooldown))
+	}
+	if a.Targeting == Self && c.Target != c.From {
+		return reject(fmt.Sprintf("%s can only %s itself", u.Name, a.Name))
+	}
+	if d := Distance(c.From, c.Target); d > a.Range {
+		return reject(fmt.Sprintf("%s can only reach %d tiles with %s, that's %d away", u.Name, a.Range, a.Name, d))
+	}
+	if a.Name == Regenerate && u.HP >= u.StartingHP {
+		return reject(fmt.Sprintf("%s is already at full health", u.Name))
+	}
+
+	ns := s.Clone()
+	events := []Event{AbilityUsed{Unit: u, Ability: a, From: c.From, Target: c.Target}}
+	switch a.Name {
+	case SonicBlast:
+		for _, p := range ns.Blast(a, c.Target) {
+			v := &ns.Cells[p.Row][p.Col]
+			if !v.Present || v.BotUnit == u.BotUnit {
+				continue
+			}
+			damage := min(a.Power, v.HP)
+			v.HP -= damage
+			events = append(events, Damaged{Unit: *v, At: p, Damage: damage, Died: v.HP <= 0})
+			if v.HP <= 0 {
+				*v = Unit{}
+			}
+		}
+	case Regenerate:
+		v := &ns.Cells[c.From.Row][c.From.Col]
+		amount := min(a.Power, v.StartingHP-v.HP)
+		v.HP += amount
+		events = append(events, Healed{Unit: *v, At: c.From, Amount: amount})
+	}
+	ns.tickCooldowns(u.Side())
+	ns.Cells[c.From.Row][c.From.Col].Cooldown = a.Cooldown
+	return ns, events
+}
+
+// tickCooldowns brings every ability on a side one move closer to ready,
+// once that side has had its go.
+func (s *State) tickCooldowns(side Side) {
+	for _, p := range s.Units(side) {
+		if u := &s.Cells[p.Row][p.Col]; u.Cooldown > 0 {
+			u.Cooldown--
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package engine
+
+import "math"
+
+const (
+	overwhelm_threshold float64 = 5.0
+
+	// Rolls above this hand the exchange to the underdog
+	underdog_roll = 80
+)
+
+// Outcome is how a fight ended for the two units in it.
+type Outcome int
+
+const (
+	AttackerWon Outcome = iota
+	DefenderWon
+	BothLived
+	BothDied
+)
+
+// CombatResult is everything about one fight, so logging, animation, stats
+// and replays can all be driven from it rather than from the units after the
+// fact.
+type CombatResult struct {
+	// The units going in, and coming out
+	Attacker      Unit
+	Defender      Unit
+	AttackerAfter Unit
+	DefenderAfter Unit
+
+	// The d100 roll, 0 to 99
+	Roll      int
+	Imbalance float64
+	// Set when the imbalance is too big for the underdog rule to apply
+	Overwhelming bool
+	// Set when the roll handed the exchange to the side the imbalance was
+	// against
+	Underdog bool
+	// Who won the exchange and landed the full strike
+	AttackerWonExchange bool
+
+	// Damage the defender took, and the attacker took back
+	DamageDealt int
+	DamageTaken int
+
+	AttackerDied bool
+	DefenderDied bool
+}
+
+// Outcome reads the casualties as a result.
+func (r CombatResult) Outcome() Outcome {
+	switch {
+	case r.AttackerDied && r.DefenderDied:
+		return BothDied
+	case r.DefenderDied:
+		return AttackerWon
+	case r.AttackerDied:
+		return DefenderWon
+	}
+	return BothLived
+}
+
+// strike is the damage one blow from a unit does to another. Every blow
+// that lands does at least 1.
+func strike(from, to Unit) int {
+	return max(1, from.Offense-to.Defense)
+}
+
+// counter is the blow a unit that lost the exchange hits back with, half a
+// strike rounded up so it still lands.
+func counter(from, to Unit) int {
+	return (strike(from, to) + 1) / 2
+}
+
+func (self *Unit) hurt(damage int) {
+	self.HP = max(0, self.HP-damage)
+}
+
+// AttackEnemy fights opp with a d100 roll, 0 to 99. The side with the power
+// imbalance in its favour wins the exchange, except that the underdog takes
+// it on a roll over 80 unless the imbalance is overwhelming. The winner lands
+// a full strike, and the loser, if it's still standing, hits back for half,
+// rounded up.
+func (self *Unit) AttackEnemy(opp *Unit, roll int) CombatResult {
+	var imbalance float64 = float64(self.Offense - (opp.Defense))
+	res := CombatResult{
+		Attacker:     *self,
+		Defender:     *opp,
+		Roll:         roll,
+		Imbalance:    imbalance,
+		Overwhelming: math.Abs(imbalance) >= overwhelm_threshold,
+	}
+
+	lost := math.Signbit(imbalance)
+
+	// 19% chance of an underdog win (rolls 81 to 99), unless the difference
+	// is massive
+	if roll > underdog_roll && !res.Overwhelming {
+		lost = !lost
+		res.Underdog = true
+	}
+	res.AttackerWonExchange = !lost
+
+	if lost {
+		// Attacker lost the exchange...
+		res.DamageTaken = strike(*opp, *self)
+		self.hurt(res.DamageTaken)
+		if self.HP > 0 {
+			res.DamageDealt = counter(*self, *opp)
+			opp.hurt(res.DamageDealt)
+		}
+	} else {
+		// Attacker overcame odds!
+		res.DamageDealt = strike(*self, *opp)
+		opp.hurt(res.DamageDealt)
+		if opp.HP > 0 {
+			res.DamageTaken = counter(*opp, *self)
+			self.hurt(res.DamageTaken)
+		}
+	}
+	res.AttackerAfter = *self
+	res.DefenderAfter = *opp
+	res.AttackerDied = self.HP <= 0
+	res.DefenderDied = opp.HP <= 0
+	return res
+}
+
+// Prediction is what can come of an attack before it's made.
+type Prediction struct {
+	Imbalance    float64
+	Overwhelming bool
+	// Exact chance of the attacker winning the exchange
+	WinChance float64
+	// How the fight plays out if the attacker wins or loses the exchange.
+	// When one of them can't happen it's the same as the other.
+	IfWon  CombatResult
+	IfLost CombatResult
+}
+
+// UnderdogChance is the chance of a roll going to the underdog, when the
+// imbalance isn't overwhelming.
+const UnderdogChance = float64(99-underdog_roll) / 100
+
+// Predict works out the odds of atk attacking def, without rolling.
+func Predict(atk, def Unit) Prediction {
+	// Roll 0 never upsets anything, roll 99 always does if it can
+	a1, d1 := atk, def
+	favoured := a1.AttackEnemy(&d1, 0)
+	a2, d2 := atk, def
+	upset := a2.AttackEnemy(&d2, 99)
+
+	p := Prediction{
+		Imbalance:    favoured.Imbalance,
+		Overwhelming: favoured.Overwhelming,
+	}
+	upsetChance := UnderdogChance
+	if p.Overwhelming {
+		upsetChance = 0
+	}
+	if favoured.AttackerWonExchange {
+		p.WinChance = 1 - upsetChance
+		p.IfWon, p.IfLost = favoured, upset
+	} else {
+		p.WinChance = upsetChance
+		p.IfWon, p.IfLost = upset, favoured
+	}
+	return p
+}
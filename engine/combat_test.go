@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func fighter(hp, off, def int) Unit {
+	return Unit{Name: "fighter", HP: hp, StartingHP: hp, Offense: off, Defense: def, Present: true}
+}
+
+func TestAttackEnemy(t *testing.T) {
+	tests := []struct {
+		name     string
+		atk, def Unit
+		roll     int
+		won      bool
+		underdog bool
+		dealt    int
+		taken    int
+		outcome  Outcome
+	}{
+		{"favoured wins", fighter(5, 4, 2), fighter(5, 3, 2), 0, true, false, 2, 1, BothLived},
+		{"roll 80 isn't an upset", fighter(5, 4, 2), fighter(5, 3, 2), 80, true, false, 2, 1, BothLived},
+		{"roll 81 is an upset", fighter(5, 4, 2), fighter(5, 3, 2), 81, false, true, 1, 1, BothLived},
+		{"underdog loses", fighter(5, 1, 2), fighter(5, 3, 3), 50, false, false, 1, 1, BothLived},
+		{"underdog wins on 99", fighter(5, 1, 2), fighter(5, 3, 3), 99, true, true, 1, 1, BothLived},
+		{"overwhelming ignores the roll", fighter(5, 7, 2), fighter(8, 3, 2), 99, true, false, 5, 1, BothLived},
+		{"overwhelmed can't upset", fighter(8, 1, 2), fighter(5, 8, 6), 99, false, false, 1, 6, BothLived},
+		{"kill skips the counter", fighter(5, 4, 2), fighter(2, 3, 2), 0, true, false, 2, 0, AttackerWon},
+		{"killed attacker can't counter", fighter(1, 4, 2), fighter(5, 3, 2), 99, false, true, 0, 1, DefenderWon},
+		// A counter off a strike of 1 used to round down to nothing
+		{"weakest counter still lands", fighter(5, 2, 9), fighter(5, 1, 2), 0, true, false, 1, 1, BothLived},
+		{"even fight goes to the attacker", fighter(5, 2, 2), fighter(5, 2, 2), 0, true, false, 1, 1, BothLived},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atk, def := tt.atk, tt.def
+			res := atk.AttackEnemy(&def, tt.roll)
+			if res.AttackerWonExchange != tt.won {
+				t.Errorf("AttackerWonExchange = %v, want %v", res.AttackerWonExchange, tt.won)
+			}
+			if res.Underdog != tt.underdog {
+				t.Errorf("Underdog = %v, want %v", res.Underdog, tt.underdog)
+			}
+			if res.DamageDealt != tt.dealt || res.DamageTaken != tt.taken {
+				t.Errorf("dealt %d and took %d, want %d and %d", res.DamageDealt, res.DamageTaken, tt.dealt, tt.taken)
+			}
+			if res.Outcome() != tt.outcome {
+				t.Errorf("Outcome = %v, want %v", res.Outcome(), tt.outcome)
+			}
+			if atk.HP != tt.atk.HP-tt.taken || def.HP != tt.def.HP-tt.dealt {
+				t.Errorf("HP went to %d and %d", atk.HP, def.HP)
+			}
+			if res.AttackerAfter != atk || res.DefenderAfter != def {
+				t.Error("result doesn't match the units coming out")
+			}
+		})
+	}
+}
+
+func TestPredict(t *testing.T) {
+	tests := []struct {
+		name         string
+		atk, def     Unit
+		chance       float64
+		overwhelming bool
+	}{
+		{"favoured", fighter(5, 4, 2), fighter(5, 3, 2), 1 - UnderdogChance, false},
+		{"underdog", fighter(5, 1, 2), fighter(5, 3, 3), UnderdogChance, false},
+		{"overwhelming", fighter(5, 7, 2), fighter(5, 3, 2), 1, true},
+		{"overwhelmed", fighter(5, 1, 2), fighter(5, 3, 6), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Predict(tt.atk, tt.def)
+			if math.Abs(p.WinChance-tt.chance) > 1e-9 {
+				t.Errorf("WinChance = %v, want %v", p.WinChance, tt.chance)
+			}
+			if p.Overwhelming != tt.overwhelming {
+				t.Errorf("Overwhelming = %v, want %v", p.Overwhelming, tt.overwhelming)
+			}
+			if tt.chance > 0 && !p.IfWon.AttackerWonExchange {
+				t.Error("IfWon is a lost exchange")
+			}
+			if tt.chance < 1 && p.IfLost.AttackerWonExchange {
+				t.Error("IfLost is a won exchange")
+			}
+		})
+	}
+}
+
+// TestUnderdogChance rolls every d100 and checks the underdog takes exactly
+// the share Predict says it does.
+func TestUnderdogChance(t *testing.T) {
+	if math.Abs(UnderdogChance-0.19) > 1e-9 {
+		t.Fatalf("UnderdogChance = %v, want 0.19", UnderdogChance)
+	}
+	wins := 0
+	for roll := 0; roll < 100; roll++ {
+		atk, def := fighter(5, 1, 2), fighter(5, 3, 3)
+		if atk.AttackEnemy(&def, roll).AttackerWonExchange {
+			wins++
+		}
+	}
+	if want := int(math.Round(UnderdogChance * 100)); wins != want {
+		t.Errorf("underdog won on %d rolls of 100, want %d", wins, want)
+	}
+}
@@ -0,0 +1,168 @@
+package engine
+
+import "container/heap"
+
+// The board is a grid of flat-topped hexes in "odd-q" offset layout: tiles
+// sit in columns, and every odd column is pushed down by half a tile. That's
+// how tileScreenPos draws them. For maths the offset coordinates are turned
+// into cube coordinates, where distance is easy.
+
+func (p Pos) cube() (int, int, int) {
+	x := p.Col
+	z := p.Row - (p.Col-(p.Col&1))/2
+	return x, -x - z, z
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Distance is the number of steps between two tiles, ignoring whatever is
+// standing in the way.
+func Distance(a, b Pos) int {
+	ax, ay, az := a.cube()
+	bx, by, bz := b.cube()
+	return max(abs(ax-bx), abs(ay-by), abs(az-bz))
+}
+
+var (
+	evenColNeighbours = [6]Pos{{-1, 0}, {1, 0}, {-1, -1}, {0, -1}, {-1, 1}, {0, 1}}
+	oddColNeighbours  = [6]Pos{{-1, 0}, {1, 0}, {0, -1}, {1, -1}, {0, 1}, {1, 1}}
+)
+
+// Neighbours lists the tiles touching p that are on the board.
+func (s State) Neighbours(p Pos) []Pos {
+	offsets := evenColNeighbours
+	if p.Col&1 == 1 {
+		offsets = oddColNeighbours
+	}
+	out := make([]Pos, 0, 6)
+	for _, o := range offsets {
+		n := Pos{Row: p.Row + o.Row, Col: p.Col + o.Col}
+		if s.InBounds(n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Within lists every tile on the board no more than radius steps from p,
+// p included.
+func (s State) Within(p Pos, radius int) []Pos {
+	var out []Pos
+	for r := range s.Cells {
+		for c := range s.Cells[r] {
+			if q := (Pos{Row: r, Col: c}); Distance(p, q) <= radius {
+				out = append(out, q)
+			}
+		}
+	}
+	return out
+}
+
+// Reach is everywhere a unit can get to this turn: the empty tiles it can
+// walk onto and the enemies it can walk up to and attack, with how many
+// steps each takes. Units block the way, friend or foe, unless it flies.
+type Reach struct {
+	Moves   map[Pos]int
+	Attacks map[Pos]int
+}
+
+// Reachable floods out from the unit on from as far as its MoveRange.
+func (s State) Reachable(from Pos) Reach {
+	unit := s.At(from)
+	reach := Reach{Moves: make(map[Pos]int), Attacks: make(map[Pos]int)}
+	if !unit.Present {
+		return reach
+	}
+	frontier := []Pos{from}
+	dist := map[Pos]int{from: 0}
+	for len(frontier) > 0 {
+		p := frontier[0]
+		frontier = frontier[1:]
+		d := dist[p] + 1
+		if d > unit.MoveRange {
+			continue
+		}
+		for _, n := range s.Neighbours(p) {
+			if _, seen := dist[n]; seen {
+				continue
+			}
+			other := s.At(n)
+			if other.Present {
+				if other.BotUnit != unit.BotUnit {
+					if old, ok := reach.Attacks[n]; !ok || d < old {
+						reach.Attacks[n] = d
+					}
+				}
+				if !unit.has(Flight) {
+					continue
+				}
+			} else {
+				reach.Moves[n] = d
+			}
+			dist[n] = d
+			frontier = append(frontier, n)
+		}
+	}
+	return reach
+}
+
+// FindPath is an A* search for the shortest walk from one tile to another,
+// through empty tiles only, or over anything for a unit that flies. The
+// destination itself may be occupied, which is how an attack path ends. The
+// path includes both ends.
+func (s State) FindPath(from, to Pos) ([]Pos, bool) {
+	if !s.InBounds(from) || !s.InBounds(to) {
+		return nil, false
+	}
+	open := &pathQueue{{pos: from, cost: Distance(from, to)}}
+	steps := map[Pos]int{from: 0}
+	cameFrom := make(map[Pos]Pos)
+	flying := s.At(from).has(Flight)
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(pathNode).pos
+		if cur == to {
+			path := []Pos{to}
+			for cur != from {
+				cur = cameFrom[cur]
+				path = append([]Pos{cur}, path...)
+			}
+			return path, true
+		}
+		for _, n := range s.Neighbours(cur) {
+			if n != to && !flying && s.At(n).Present {
+				continue
+			}
+			d := steps[cur] + 1
+			if old, ok := steps[n]; ok && old <= d {
+				continue
+			}
+			steps[n] = d
+			cameFrom[n] = cur
+			heap.Push(open, pathNode{pos: n, cost: d + Distance(n, to)})
+		}
+	}
+	return nil, false
+}
+
+type pathNode struct {
+	pos  Pos
+	cost int
+}
+
+type pathQueue []pathNode
+
+func (q pathQueue) Len() int           { return len(q) }
+func (q pathQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x any)        { *q = append(*q, x.(pathNode)) }
+func (q *pathQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
@@ -0,0 +1,112 @@
+package engine
+
+import "testing"
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b Pos
+		want int
+	}{
+		{Pos{0, 0}, Pos{0, 0}, 0},
+		{Pos{0, 0}, Pos{1, 0}, 1},
+		{Pos{0, 0}, Pos{0, 1}, 1},
+		// Odd columns sit half a tile lower, so from an even column the row
+		// below isn't touching
+		{Pos{0, 0}, Pos{1, 1}, 2},
+		{Pos{1, 1}, Pos{0, 0}, 2},
+		{Pos{1, 1}, Pos{2, 0}, 1},
+		{Pos{1, 1}, Pos{2, 2}, 1},
+		{Pos{1, 2}, Pos{0, 1}, 1},
+		{Pos{0, 0}, Pos{0, 4}, 4},
+		{Pos{0, 0}, Pos{4, 0}, 4},
+		{Pos{0, 0}, Pos{3, 3}, 5},
+		{Pos{4, 1}, Pos{0, 2}, 5},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Distance(tt.b, tt.a); got != tt.want {
+			t.Errorf("Distance(%v, %v) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestNeighboursAreOneStep(t *testing.T) {
+	s := NewState(6, 6)
+	for r := range s.Cells {
+		for c := range s.Cells[r] {
+			p := Pos{r, c}
+			want := 0
+			for _, q := range s.Within(p, 1) {
+				if q != p {
+					want++
+				}
+			}
+			ns := s.Neighbours(p)
+			if len(ns) != want {
+				t.Errorf("%v has %d neighbours, want %d", p, len(ns), want)
+			}
+			for _, n := range ns {
+				if d := Distance(p, n); d != 1 {
+					t.Errorf("neighbour %v of %v is %d away", n, p, d)
+				}
+			}
+		}
+	}
+}
+
+func TestFindPath(t *testing.T) {
+	wall := func(s State) State {
+		// A wall down column 2 with a gap at the bottom
+		for r := 0; r < 4; r++ {
+			s.Cells[r][2] = testUnit("wall", true)
+		}
+		return s
+	}
+	tests := []struct {
+		name     string
+		state    State
+		flying   bool
+		from, to Pos
+		steps    int
+		ok       bool
+	}{
+		{"straight line", NewState(6, 6), false, Pos{0, 0}, Pos{0, 5}, 5, true},
+		{"diagonal", NewState(6, 6), false, Pos{0, 0}, Pos{5, 5}, Distance(Pos{0, 0}, Pos{5, 5}), true},
+		{"around a wall", wall(NewState(5, 5)), false, Pos{0, 0}, Pos{0, 4}, 10, true},
+		{"over a wall", wall(NewState(5, 5)), true, Pos{0, 0}, Pos{0, 4}, 4, true},
+		{"onto an enemy", wall(NewState(5, 5)), false, Pos{0, 1}, Pos{0, 2}, 1, true},
+		{"off the board", NewState(3, 3), false, Pos{0, 0}, Pos{3, 0}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := testUnit("walker", false)
+			if tt.flying {
+				u.Ability = Flight
+			}
+			tt.state.Cells[tt.from.Row][tt.from.Col] = u
+			path, ok := tt.state.FindPath(tt.from, tt.to)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if path[0] != tt.from || path[len(path)-1] != tt.to {
+				t.Errorf("path %v doesn't run from %v to %v", path, tt.from, tt.to)
+			}
+			if len(path)-1 != tt.steps {
+				t.Errorf("path %v takes %d steps, want %d", path, len(path)-1, tt.steps)
+			}
+			for i := 1; i < len(path); i++ {
+				if d := Distance(path[i-1], path[i]); d != 1 {
+					t.Errorf("step %v to %v is %d tiles", path[i-1], path[i], d)
+				}
+				if i < len(path)-1 && !tt.flying && tt.state.At(path[i]).Present {
+					t.Errorf("path walks through %v", path[i])
+				}
+			}
+		})
+	}
+}
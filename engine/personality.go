@@ -0,0 +1,38 @@
+package engine
+
+// Personality is how a faction weighs things up when the search plays it.
+type Personality struct {
+	Name string
+	// How much the faction's own units are worth to it. Below 1 it throws
+	// them away, above 1 it hangs on to them.
+	Caution float64
+	// How much each step closer to the enemy is worth to its units
+	Approach float64
+}
+
+// Personalities a faction can have, as unit files spell them.
+const (
+	Balanced   = "balanced"
+	Aggressive = "aggressive"
+	Cautious   = "cautious"
+)
+
+var personalities = map[string]Personality{
+	Balanced:   {Name: Balanced, Caution: 1, Approach: 0.1},
+	Aggressive: {Name: Aggressive, Caution: 0.5, Approach: 0.5},
+	Cautious:   {Name: Cautious, Caution: 1.5, Approach: 0.02},
+}
+
+// LookupPersonality finds a personality by name.
+func LookupPersonality(name string) (Personality, bool) {
+	p, ok := personalities[name]
+	return p, ok
+}
+
+// personalityOf is the personality the unit's faction plays with.
+func personalityOf(u Unit, byFaction map[string]Personality) Personality {
+	if p, ok := byFaction[u.Faction]; ok {
+		return p
+	}
+	return personalities[Balanced]
+}
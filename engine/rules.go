@@ -0,0 +1,117 @@
+package engine
+
+import "fmt"
+
+// Event is something that happened while applying a move, for frontends to
+// show however they like.
+type Event interface {
+	isEvent()
+}
+
+// Moved is a unit walking onto an empty tile.
+type Moved struct {
+	Unit Unit
+	From Pos
+	To   Pos
+	Path []Pos
+}
+
+// Attacked is one unit attacking another across the board.
+type Attacked struct {
+	From Pos
+	To   Pos
+	CombatResult
+}
+
+// FriendlyFire is a unit trying to attack its own side. Nothing happens.
+type FriendlyFire struct {
+	Unit Unit
+	At   Pos
+}
+
+// Rejected is a move the rules don't allow. The State is left alone.
+type Rejected struct {
+	Move   Move
+	Reason string
+}
+
+func (Moved) isEvent()        {}
+func (Attacked) isEvent()     {}
+func (FriendlyFire) isEvent() {}
+func (Rejected) isEvent()     {}
+
+// ApplyMove plays one move and returns the resulting State along with what
+// happened. s itself is never modified.
+func ApplyMove(s State, m Move) (State, []Event) {
+	return applyMove(s, m, (*State).rollD100)
+}
+
+// applyMove is ApplyMove with the dice left to roll, so the search can try
+// each way a fight can go.
+func applyMove(s State, m Move, roll func(*State) int) (State, []Event) {
+	if !s.InBounds(m.From) || !s.InBounds(m.To) {
+		return s, []Event{Rejected{Move: m, Reason: "that tile isn't on the board"}}
+	}
+	if m.From == m.To {
+		return s, []Event{Rejected{Move: m, Reason: "a unit can't move onto itself"}}
+	}
+	source := s.At(m.From)
+	target := s.At(m.To)
+	if !source.Present {
+		return s, []Event{Rejected{Move: m, Reason: "there's no one there to move"}}
+	}
+	if target.Present && source.BotUnit == target.BotUnit {
+		return s, []Event{FriendlyFire{Unit: source, At: m.From}}
+	}
+	path, ok := s.FindPath(m.From, m.To)
+	if !ok {
+		return s, []Event{Rejected{Move: m, Reason: fmt.Sprintf("%s can't find a way through", source.Name)}}
+	}
+	if steps := len(path) - 1; steps > source.MoveRange {
+		return s, []Event{Rejected{Move: m, Reason: fmt.Sprintf("%s can only move %d tiles, that's %d away", source.Name, source.MoveRange, steps)}}
+	}
+
+	ns := s.Clone()
+	src := &ns.Cells[m.From.Row][m.From.Col]
+	dst := &ns.Cells[m.To.Row][m.To.Col]
+	if !target.Present {
+		// No one's here, they can just move.
+		*dst = *src
+		*src = Unit{}
+		ns.tickCooldowns(source.Side())
+		return ns, []Event{Moved{Unit: source, From: m.From, To: m.To, Path: path}}
+	}
+
+	// Both sides fight with whatever their neighbours inspire in them, but
+	// only the damage sticks
+	atk, def := ns.Inspired(m.From), ns.Inspired(m.To)
+	ev := Attacked{From: m.From, To: m.To, CombatResult: atk.AttackEnemy(&def, roll(&ns))}
+	src.HP, dst.HP = atk.HP, def.HP
+	if src.HP <= 0 {
+		// Attacker died, delete them
+		*src = Unit{}
+	}
+	if dst.HP <= 0 {
+		// Defender died, and a surviving attacker moves into the cell
+		*dst = *src
+		*src = Unit{}
+	}
+	ns.tickCooldowns(source.Side())
+	return ns, []Event{ev}
+}
+
+// Winner reports which side has won, once the other has no units left on
+// the board.
+func Winner(s State) (Side, bool) {
+	players := len(s.Units(Player))
+	bots := len(s.Units(Bot))
+	switch {
+	case players+bots == 0:
+		return Player, false
+	case bots == 0:
+		return Player, true
+	case players == 0:
+		return Bot, true
+	}
+	return Player, false
+}
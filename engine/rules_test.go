@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func testUnit(name string, bot bool) Unit {
+	return Unit{
+		Name:       name,
+		MoveRange:  2,
+		HP:         5,
+		StartingHP: 5,
+		Offense:    3,
+		Defense:    2,
+		BotUnit:    bot,
+		Present:    true,
+	}
+}
+
+func TestApplyMoveRejects(t *testing.T) {
+	s := NewState(5, 5)
+	s.Cells[2][2] = testUnit("ours", false)
+	s.Cells[2][3] = testUnit("friend", false)
+	s.Cells[0][0] = testUnit("theirs", true)
+
+	tests := []struct {
+		name   string
+		move   Move
+		reason string
+	}{
+		{"from off the board", Move{From: Pos{-1, 0}, To: Pos{0, 0}}, "isn't on the board"},
+		{"to off the board", Move{From: Pos{2, 2}, To: Pos{2, 5}}, "isn't on the board"},
+		{"onto itself", Move{From: Pos{2, 2}, To: Pos{2, 2}}, "onto itself"},
+		{"empty tile", Move{From: Pos{4, 4}, To: Pos{4, 3}}, "no one there"},
+		{"too far", Move{From: Pos{2, 2}, To: Pos{4, 4}}, "can only move 2 tiles, that's 3 away"},
+		{"too far to attack", Move{From: Pos{2, 2}, To: Pos{0, 0}}, "can only move 2 tiles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns, events := ApplyMove(s, tt.move)
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			rej, ok := events[0].(Rejected)
+			if !ok {
+				t.Fatalf("got %T, want Rejected", events[0])
+			}
+			if !strings.Contains(rej.Reason, tt.reason) {
+				t.Errorf("reason %q doesn't mention %q", rej.Reason, tt.reason)
+			}
+			if !ns.Equal(s) {
+				t.Error("a rejected move changed the board")
+			}
+		})
+	}
+}
+
+func TestApplyMoveFriendlyFire(t *testing.T) {
+	s := NewState(3, 3)
+	s.Cells[1][1] = testUnit("ours", false)
+	s.Cells[0][1] = testUnit("friend", false)
+
+	ns, events := ApplyMove(s, Move{From: Pos{1, 1}, To: Pos{0, 1}})
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if _, ok := events[0].(FriendlyFire); !ok {
+		t.Fatalf("got %T, want FriendlyFire", events[0])
+	}
+	if !ns.Equal(s) {
+		t.Error("friendly fire changed the board")
+	}
+}
+
+func TestApplyMoveWalks(t *testing.T) {
+	s := NewState(3, 3)
+	s.Cells[0][0] = testUnit("ours", false)
+
+	ns, events := ApplyMove(s, Move{From: Pos{0, 0}, To: Pos{2, 0}})
+	moved, ok := events[0].(Moved)
+	if !ok {
+		t.Fatalf("got %T, want Moved", events[0])
+	}
+	if len(moved.Path) != 3 {
+		t.Errorf("path %v, want 3 tiles", moved.Path)
+	}
+	if ns.At(Pos{0, 0}).Present || ns.At(Pos{2, 0}).Name != "ours" {
+		t.Error("unit didn't end up on the tile it moved to")
+	}
+	if !s.At(Pos{0, 0}).Present {
+		t.Error("ApplyMove changed the State it was given")
+	}
+}
@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"context"
+	"math"
+)
+
+// Action is a side's whole go: a move, which may be an attack, or using an
+// ability.
+type Action struct {
+	From    Pos
+	To      Pos
+	Ability bool
+}
+
+// Apply plays the action like ApplyMove or ApplyAbility would.
+func (a Action) Apply(s State) (State, []Event) {
+	if a.Ability {
+		return ApplyAbility(s, Cast{From: a.From, Target: a.To})
+	}
+	return ApplyMove(s, Move{From: a.From, To: a.To})
+}
+
+// Other is the side a side plays against.
+func (side Side) Other() Side {
+	if side == Bot {
+		return Player
+	}
+	return Bot
+}
+
+// Actions lists everything side could do with its go, attacks first since
+// they're the most likely to matter. Abilities are only listed where they'd
+// do something.
+func (s State) Actions(side Side) []Action {
+	var attacks, casts, moves []Action
+	for _, from := range s.Units(side) {
+		reach := s.Reachable(from)
+		for to := range reach.Attacks {
+			attacks = append(attacks, Action{From: from, To: to})
+		}
+		for to := range reach.Moves {
+			moves = append(moves, Action{From: from, To: to})
+		}
+		ability, _ := s.At(from).Active()
+		for _, to := range s.CastTargets(from) {
+			if s.castMatters(ability, from, to) {
+				casts = append(casts, Action{From: from, To: to, Ability: true})
+			}
+		}
+	}
+	return append(append(attacks, casts...), moves...)
+}
+
+func (s State) castMatters(a Ability, from, to Pos) bool {
+	u := s.At(from)
+	switch a.Targeting {
+	case Self:
+		return u.HP < u.StartingHP
+	case Area:
+		for _, p := range s.Blast(a, to) {
+			if v := s.At(p); v.Present && v.BotUnit != u.BotUnit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Score for winning, from the point of view of the side being scored
+const winScore = 1e6
+
+// worth is what a unit brings to a fight, scaled by how much of it is left.
+func worth(u Unit) float64 {
+	w := float64(u.Offense + u.Defense + u.MoveRange/2 + 1)
+	if _, ok := LookupAbility(u.Ability); ok {
+		w += 2
+	}
+	return w * float64(u.HP) / float64(max(u.StartingHP, 1))
+}
+
+// Evaluate scores a board for side: what its army is worth against the
+// other's, and a little for every unit being close to the enemy. Each unit
+// counts the way its faction's personality sees it, Balanced for factions
+// missing from personalities.
+func Evaluate(s State, side Side, personalities map[string]Personality) float64 {
+	if winner, over := Winner(s); over {
+		if winner == side {
+			return winScore
+		}
+		return -winScore
+	}
+	closest := func(from Pos, targets []Pos) int {
+		best := 0
+		for k, t := range targets {
+			if d := Distance(from, t); k == 0 || d < best {
+				best = d
+			}
+		}
+		return best
+	}
+	score := 0.0
+	for _, sd := range []Side{side, side.Other()} {
+		sign := 1.0
+		if sd != side {
+			sign = -1
+		}
+		enemies := s.Units(sd.Other())
+		for _, p := range s.Units(sd) {
+			u := s.At(p)
+			pers := personalityOf(u, personalities)
+			score += sign * (pers.Caution*worth(u) - pers.Approach*float64(closest(p, enemies)))
+		}
+	}
+	return score
+}
+
+// SearchOptions tunes a search.
+type SearchOptions struct {
+	// How many moves ahead to look at most, time allowing
+	Depth int
+	// How each faction plays, by name
+	Personalities map[string]Personality
+	// Allowed says which actions the side may take right now, nil for all
+	// of them. Only the action being picked is filtered, further ahead every
+	// unit is taken to be ready.
+	Allowed func(State, Action) bool
+}
+
+// SearchResult is the action a search settled on.
+type SearchResult struct {
+	Action Action
+	Score  float64
+	// How many moves ahead the search got to, and how many boards it looked
+	// at on the way
+	Depth int
+	Nodes int
+}
+
+type searcher struct {
+	ctx     context.Context
+	opts    SearchOptions
+	nodes   int
+	stopped bool
+}
+
+// expired counts a board looked at and checks whether time's up. Boards are
+// slow enough to expand that asking the context every time is cheap.
+func (sr *searcher) expired() bool {
+	sr.nodes++
+	if !sr.stopped && sr.ctx.Err() != nil {
+		sr.stopped = true
+	}
+	return sr.stopped
+}
+
+// outcome is one way an action can turn out and how likely it is.
+type outcome struct {
+	state  State
+	chance float64
+}
+
+// outcomes plays an action every way it can go. Only attacks have more than
+// one: the favourite winning the exchange, or the underdog's upset.
+func (s State) outcomes(a Action) []outcome {
+	target := s.At(a.To)
+	if a.Ability || !target.Present {
+		next, events := a.Apply(s)
+		if _, rejected := events[0].(Rejected); rejected {
+			return nil
+		}
+		return []outcome{{next, 1}}
+	}
+	p := Predict(s.Inspired(a.From), s.Inspired(a.To))
+	favoured, _ := applyMove(s, Move{From: a.From, To: a.To}, func(*State) int { return 0 })
+	if p.Overwhelming {
+		return []outcome{{favoured, 1}}
+	}
+	upset, _ := applyMove(s, Move{From: a.From, To: a.To}, func(*State) int { return 99 })
+	return []outcome{{favoured, 1 - UnderdogChance}, {upset, UnderdogChance}}
+}
+
+// value is expectimax over the sides taking turns: each side picks its best
+// action, and fights are averaged over how the dice can fall.
+func (sr *searcher) value(s State, side Side, depth int) float64 {
+	if _, over := Winner(s); over || depth == 0 || sr.expired() {
+		return Evaluate(s, side, sr.opts.Personalities)
+	}
+	actions := s.Actions(side)
+	if len(actions) == 0 {
+		return Evaluate(s, side, sr.opts.Personalities)
+	}
+	best := math.Inf(-1)
+	for _, a := range actions {
+		v := sr.expect(s, a, side, depth)
+		best = max(best, v)
+		if sr.stopped {
+			break
+		}
+	}
+	return best
+}
+
+func (sr *searcher) expect(s State, a Action, side Side, depth int) float64 {
+	outcomes := s.outcomes(a)
+	if len(outcomes) == 0 {
+		return math.Inf(-1)
+	}
+	v := 0.0
+	for _, o := range outcomes {
+		v -= o.chance * sr.value(o.state, side.Other(), depth-1)
+	}
+	return v
+}
+
+// Search looks for side's best action on s, an expectimax search deepened
+// one move at a time until opts.Depth or until ctx is done, whichever comes
+// first. Only fully searched depths count. ok is false if side has nothing
+// it can do.
+func Search(ctx context.Context, s State, side Side, opts SearchOptions) (res SearchResult, ok bool) {
+	var actions []Action
+	for _, a := range s.Actions(side) {
+		if opts.Allowed == nil || opts.Allowed(s, a) {
+			actions = append(actions, a)
+		}
+	}
+	if len(actions) == 0 {
+		return SearchResult{}, false
+	}
+	res.Action = actions[0]
+	sr := &searcher{ctx: ctx, opts: opts}
+	for depth := 1; depth <= opts.Depth; depth++ {
+		best, bestScore := actions[0], math.Inf(-1)
+		for _, a := range actions {
+			if v := sr.expect(s, a, side, depth); v > bestScore {
+				best, bestScore = a, v
+			}
+			if sr.stopped {
+				break
+			}
+		}
+		if sr.stopped {
+			break
+		}
+		res.Action, res.Score, res.Depth = best, bestScore, depth
+		if bestScore >= winScore {
+			// Can't do better than winning
+			break
+		}
+		// Look at the best line first next time round
+		for k, a := range actions {
+			if a == best {
+				actions[0], actions[k] = actions[k], actions[0]
+				break
+			}
+		}
+	}
+	res.Nodes = sr.nodes
+	return res, true
+}
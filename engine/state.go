@@ -0,0 +1,97 @@
+// Package engine holds the rules of the game, free of any rendering or
+// input, so they can be run headless by tests, bots, servers or any other
+// frontend.
+package engine
+
+// Pos is a cell on the board. Row is the first index into State.Cells and
+// Col the second, the same way TileGrid.Tiles is indexed.
+type Pos struct {
+	Row int
+	Col int
+}
+
+// State is a position: who stands where, and the dice.
+type State struct {
+	Cells [][]Unit
+
+	// RNG state, advanced by every roll so that applying the same move to
+	// the same State always plays out the same way
+	Rand uint64
+}
+
+// Move takes the unit on From to To, attacking whatever is there.
+type Move struct {
+	From Pos
+	To   Pos
+}
+
+// NewState makes an empty board.
+func NewState(rows, cols int) State {
+	s := State{Cells: make([][]Unit, rows)}
+	for r := range s.Cells {
+		s.Cells[r] = make([]Unit, cols)
+	}
+	return s
+}
+
+// Clone deep copies the board so the copy can be changed freely.
+func (s State) Clone() State {
+	ns := State{Cells: make([][]Unit, len(s.Cells)), Rand: s.Rand}
+	for r := range s.Cells {
+		ns.Cells[r] = append([]Unit(nil), s.Cells[r]...)
+	}
+	return ns
+}
+
+// Equal reports whether two boards have the same units in the same places.
+func (s State) Equal(o State) bool {
+	if len(s.Cells) != len(o.Cells) {
+		return false
+	}
+	for r := range s.Cells {
+		if len(s.Cells[r]) != len(o.Cells[r]) {
+			return false
+		}
+		for c := range s.Cells[r] {
+			if s.Cells[r][c] != o.Cells[r][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s State) InBounds(p Pos) bool {
+	return p.Row >= 0 && p.Row < len(s.Cells) && p.Col >= 0 && p.Col < len(s.Cells[p.Row])
+}
+
+// At is the unit on p, or an empty Unit off the board.
+func (s State) At(p Pos) Unit {
+	if !s.InBounds(p) {
+		return Unit{}
+	}
+	return s.Cells[p.Row][p.Col]
+}
+
+// Units lists the positions of every unit on a side.
+func (s State) Units(side Side) []Pos {
+	var out []Pos
+	for r := range s.Cells {
+		for c, u := range s.Cells[r] {
+			if u.Present && u.Side() == side {
+				out = append(out, Pos{Row: r, Col: c})
+			}
+		}
+	}
+	return out
+}
+
+// rollD100 is a splitmix64 step, giving 0 to 99.
+func (s *State) rollD100() int {
+	s.Rand += 0x9e3779b97f4a7c15
+	z := s.Rand
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return int(z % 100)
+}
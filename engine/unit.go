@@ -0,0 +1,45 @@
+package engine
+
+// Side is who a unit fights for.
+type Side int
+
+const (
+	Player Side = iota
+	Bot
+)
+
+type Unit struct {
+	Name       string
+	MoveRange  int
+	HP         int
+	StartingHP int
+	Offense    int
+	Defense    int
+	BotUnit    bool
+	Faction    string
+	Ability    string
+
+	// Moves of its side left until the unit's ability can be used again
+	Cooldown int
+	// Action points left this turn. The engine carries them around with the
+	// unit, spending them is up to whoever runs the turns.
+	AP int
+
+	// This is my replacement for an Optional<Unit> type, when going through
+	// the grid we can do "if unit.Present {".
+	Present bool
+}
+
+func (side Side) String() string {
+	if side == Bot {
+		return "bot"
+	}
+	return "player"
+}
+
+func (u Unit) Side() Side {
+	if u.BotUnit {
+		return Bot
+	}
+	return Player
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// exportMarker is a file every export leaves in the repository, so a later
+// export knows the directory is its own to replace.
+const exportMarker = "molniya-export"
+
+// exportGitRepo writes the game's history out as a bare git repository in
+// dir. The object store already hashes everything the way git does, so this
+// is just a matter of compressing each object into its loose object file and
+// writing out the refs, after which "git log --graph --all" works on it.
+//
+// The repository is built next to dir and renamed into place, and only ever
+// replaces an empty directory or an earlier export, never someone's real
+// repository.
+func exportGitRepo(tree *GridTree, dir string) error {
+	dir = filepath.Clean(dir)
+	if err := checkExportDir(dir); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".molniya-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := writeGitRepo(tree, tmp); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		old := tmp + ".old"
+		if err := os.Rename(dir, old); err != nil {
+			return err
+		}
+		defer os.RemoveAll(old)
+	}
+	return os.Rename(tmp, dir)
+}
+
+// checkExportDir refuses to export over anything but nothing, an empty
+// directory or an earlier export.
+func checkExportDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, exportMarker)); err != nil {
+		return fmt.Errorf("%s already exists and isn't an earlier export, refusing to overwrite it", dir)
+	}
+	return nil
+}
+
+// writeGitRepo lays the repository out in an empty dir.
+func writeGitRepo(tree *GridTree, dir string) error {
+	for _, sub := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	files := map[string]string{
+		"config":     "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = true\n",
+		"HEAD":       "ref: refs/heads/" + tree.head + "\n",
+		exportMarker: "Written by molniya, the next export replaces this repository.\n",
+	}
+	if tree.head == "" {
+		files["HEAD"] = tree.detached + "\n"
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, hash := range tree.objects.Hashes() {
+		path := filepath.Join(dir, "objects", hash[:2], hash[2:])
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(tree.objects.Raw(hash)); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0444); err != nil {
+			return err
+		}
+	}
+
+	heads := filepath.Join(dir, "refs", "heads")
+	for name, hash := range tree.refs {
+		path := filepath.Join(heads, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(hash+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gitExport(g *Game) {
+	g.logger.AddMessage("you$ ", "git push --mirror "+g.exportPath, false)
+	if err := exportGitRepo(&g.gridTree, g.exportPath); err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	n := g.gridTree.objects.Len()
+	g.logger.AddMessage("", fmt.Sprintf("Writing objects: 100%% (%d/%d), done.", n, n), true)
+	for _, name := range g.gridTree.branches() {
+		g.logger.AddMessage("", fmt.Sprintf(" * [new branch]      %s -> %s", name, name), true)
+	}
+}
@@ -3,131 +3,397 @@ package main
 import (
 	"fmt"
 	"image/color"
-	"math/rand"
 )
 
-type GridTree struct {
-	grid TileGrid
-	prev *GridTree
-	next *GridTree
-
-	generation int
-	commitHash string
+// setWorkingGrid swaps the board being played on for a copy of grid.
+func setWorkingGrid(g *Game, grid TileGrid) {
+	if g.selected != nil {
+		g.selected.IsSelectedGrid = false
+	}
+	g.grid = grid.Clone()
+	g.grid.clearSelection()
+	g.selected = &g.grid
+	g.selected.IsSelectedGrid = true
 }
 
-func generateRandomHash() string {
-    b := make([]byte, 20)
-    rand.Read(b)
-    return fmt.Sprintf("%x", b)
+// workingTreeDirty reports whether the board has moves that aren't committed.
+func workingTreeDirty(g *Game) bool {
+	head := g.gridTree.headCommit()
+	return g.selected != nil && head != nil && !g.selected.Equals(head.grid)
 }
 
-func gitCommitGrid(g *Game, grid TileGrid, branch bool, cls bool) string {
-	old := g.gridTree
-	if old.prev != nil {
-		old.prev.next = &old
+// gitCommitGrid commits grid as the player, first on a new branch if asked
+// to. An empty message commits with the summary of the moves made.
+func gitCommitGrid(g *Game, grid TileGrid, message string, branch bool, cls bool) string {
+	if g.merge != nil {
+		g.logger.AddMessage("", "error: Committing is not possible because you have unmerged files.", true)
+		return ""
 	}
-	node := GridTree{
-		grid:       grid,
-		prev:       &old,
-		next:       nil,
-		generation: old.generation,
-        commitHash: generateRandomHash(),
+	if cls {
+		message = "welcome to the game"
+	} else if message == "" {
+		message = "move a piece"
+		if head := g.gridTree.headCommit(); head != nil {
+			if summary := moveSummary(head.grid, grid); summary != "" {
+				message = summary
+			}
+		}
+	}
+	if branch && branchLimitReached(g) {
+		logBranchLimit(g)
+		return ""
 	}
-    if branch && node.generation == 4 {
-        g.logger.AddMessage("[!] ", "Maximum allowed branches", true)
-        return ""
-    }
 	if branch {
-		node.generation++
-        g.logger.AddMessage("you$ ", fmt.Sprintf("git checkout -b branch%d", node.generation), false)
+		name := nextBranchName(&g.gridTree)
+		g.logger.AddMessage("you$ ", fmt.Sprintf("git checkout -b %s", name), false)
+		if !checkoutNewBranch(g, name) {
+			return ""
+		}
+	}
+	if !cls {
+		g.logger.AddMessage("you$ ", "git commit -m '"+message+"'", false)
+	}
+	node := commitBoard(g, grid, message, !cls)
+	if node == nil {
+		return ""
+	}
+	return node.hash
+}
+
+// commitBoard commits grid on top of HEAD and puts it on the table. verbose
+// prints what git would.
+func commitBoard(g *Game, grid TileGrid, message string, verbose bool) *commitNode {
+	if g.merge != nil {
+		g.logger.AddMessage("", "error: Committing is not possible because you have unmerged files.", true)
+		return nil
+	}
+	var parents []string
+	if head := g.gridTree.headHash(); head != "" {
+		parents = []string{head}
+	}
+	node, err := g.gridTree.commit(grid, parents, authorPlayer, message)
+	if err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return nil
 	}
-	g.gridTree = node
-	old.next = &g.gridTree
 	g.autoScroll = true
-    if g.selected != nil {
-        g.selected.IsSelectedGrid = false
-        g.selected = nil
-    }
-	g.selected = &grid
-	g.selected.IsSelectedGrid = true
+	setWorkingGrid(g, grid)
+
+	if verbose {
+		g.logger.AddMessage("", fmt.Sprintf("[%s %s] %s", g.gridTree.branchLabel(), node.hash[0:8], message), true)
+		g.logger.AddMessage("", "1 files changed, 1 insertions(+), 0 deletions(-)", true)
+	}
+	return node
+}
+
+// startBranch points a new branch at HEAD, if it's a name git would take
+// and the game has room for another branch.
+func startBranch(g *Game, name string) bool {
+	if !validBranchName(name) {
+		g.logger.AddMessage("", fmt.Sprintf("fatal: '%s' is not a valid branch name", name), true)
+		return false
+	}
+	if _, exists := g.gridTree.refs[name]; !exists && branchLimitReached(g) {
+		logBranchLimit(g)
+		return false
+	}
+	if err := g.gridTree.createBranch(name); err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return false
+	}
+	return true
+}
+
+// checkoutNewBranch starts a branch at HEAD and switches to it. The board on
+// the table comes along, committed or not.
+func checkoutNewBranch(g *Game, name string) bool {
+	if !startBranch(g, name) {
+		return false
+	}
+	g.gridTree.checkout(name)
+	g.autoScroll = true
+	return true
+}
+
+// checkoutRev switches to a branch, or to any other commit with a detached
+// HEAD, and puts its board on the table.
+func checkoutRev(g *Game, rev string) {
+	tree := &g.gridTree
+	if g.merge != nil {
+		g.logger.AddMessage("", "error: you need to resolve your current index first", true)
+		return
+	}
+	hash, err := tree.resolve(rev)
+	if err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	_, isBranch := tree.refs[rev]
+	if rev == "HEAD" || rev == "@" || isBranch && tree.head == rev || !isBranch && tree.head == "" && tree.detached == hash {
+		g.logger.AddMessage("", "Already on '"+tree.branchLabel()+"'", true)
+		return
+	}
+	if workingTreeDirty(g) {
+		g.logger.AddMessage("", "error: Your local changes to the following files would be overwritten by checkout:", true)
+		g.logger.AddMessage("", "        board.bson", true)
+		g.logger.AddMessage("", "Please commit your changes or stash them before you switch branches.", true)
+		return
+	}
+	if isBranch {
+		tree.checkout(rev)
+		g.logger.AddMessage("", "Switched to branch '"+rev+"'", true)
+	} else {
+		tree.checkoutDetached(hash)
+		g.logger.AddMessage("", fmt.Sprintf("Note: switching to '%s'.", rev), true)
+		g.logger.AddMessage("[!] ", "You are in 'detached HEAD' state. Commits you make here belong to no branch", true)
+		g.logger.AddMessage("[!] ", "and are lost once you switch away, unless you make a branch for them.", true)
+		g.logger.AddMessage("", fmt.Sprintf("HEAD is now at %s", hash[0:7]), true)
+	}
+	setWorkingGrid(g, tree.headCommit().grid)
+	g.autoScroll = true
+}
+
+// checkoutCommit is clicking a commit in the tree view: check out the branch
+// pointing at it, or the commit itself with a detached HEAD.
+func checkoutCommit(g *Game, hash string) {
+	tree := &g.gridTree
+	rev := hash[0:7]
+	for _, name := range tree.branches() {
+		if tree.refs[name] == hash && (rev == hash[0:7] || name == tree.head) {
+			rev = name
+		}
+	}
+	g.logger.AddMessage("you$ ", "git checkout "+rev, false)
+	checkoutRev(g, rev)
+}
+
+// gameRules are the limits a game mode puts on playing with git.
+type gameRules struct {
+	// Most branches, main included, that can exist at once, 0 for no limit
+	maxBranches int
+}
+
+// branchLimitReached reports whether the game mode allows another branch.
+func branchLimitReached(g *Game) bool {
+	return g.rules.maxBranches > 0 && len(g.gridTree.refs) >= g.rules.maxBranches
+}
+
+func logBranchLimit(g *Game) {
+	g.logger.AddMessage("[!] ", fmt.Sprintf("Maximum allowed branches (%d)", g.rules.maxBranches), true)
+}
 
-    if !cls {
-        g.logger.AddMessage("you$ ", "git commit -m 'move a piece'", false)
-        g.logger.AddMessage("", fmt.Sprintf("[main %s] move a piece", node.commitHash[0:8]), true)
-        g.logger.AddMessage("", "1 files changed, 1 insertions(+), 0 deletions(-)", true)
-    }
-	return  node.commitHash
+// renameBranch is git branch -m. main is where the game is won or lost, so
+// it keeps its name.
+func renameBranch(g *Game, old, name string) {
+	if old == "main" {
+		g.logger.AddMessage("[!] ", "main is the timeline that counts, it keeps its name", true)
+		return
+	}
+	if !validBranchName(name) {
+		g.logger.AddMessage("", fmt.Sprintf("fatal: '%s' is not a valid branch name", name), true)
+		return
+	}
+	if err := g.gridTree.renameBranch(old, name); err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	if g.merge != nil && g.merge.branch == old {
+		g.merge.branch = name
+	}
+	g.logger.AddMessage("", fmt.Sprintf("Renamed branch %s to %s", old, name), true)
 }
 
+// deleteBranch is git branch -d, or -D with force. Without force only
+// branches already merged into HEAD go.
+func deleteBranch(g *Game, name string, force bool) {
+	tree := &g.gridTree
+	hash, ok := tree.refs[name]
+	switch {
+	case !ok:
+		g.logger.AddMessage("", fmt.Sprintf("error: branch '%s' not found", name), true)
+		return
+	case name == "main":
+		g.logger.AddMessage("[!] ", "main is the timeline that counts, it can't be deleted", true)
+		return
+	case g.merge != nil && g.merge.branch == name:
+		g.logger.AddMessage("", fmt.Sprintf("error: cannot delete branch '%s' while merging it", name), true)
+		return
+	case !force && !tree.isAncestor(hash, tree.headHash()):
+		g.logger.AddMessage("", fmt.Sprintf("error: the branch '%s' is not fully merged", name), true)
+		g.logger.AddMessage("", fmt.Sprintf("hint: If you are sure you want to delete it, run 'git branch -D %s'", name), true)
+		return
+	}
+	if err := tree.deleteBranch(name); err != nil {
+		g.logger.AddMessage("", "error: "+err.Error(), true)
+		return
+	}
+	g.logger.AddMessage("", fmt.Sprintf("Deleted branch %s (was %s).", name, hash[0:7]), true)
+}
+
+// nextBranchName picks the lowest free branchN name.
+func nextBranchName(t *GridTree) string {
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("branch%d", n)
+		if _, ok := t.refs[name]; !ok {
+			return name
+		}
+	}
+}
+
+// mergeCurrentBranch is the m key: merge the checked out branch back into
+// the branch it came from, and delete it.
 func mergeCurrentBranch(g *Game) {
-    fmt.Println("merge", g.gridTree.generation)
-    if g.gridTree.generation == 0 {
-        g.logger.AddMessage("[!] ", "You cannot merge", false)
-        return
-    }
-    if g.gridTree.generation - 1 > 0 {
-        g.logger.AddMessage("you$ ", fmt.Sprintf("git checkout branch%d", g.gridTree.generation - 1), false)
-    } else {
-        g.logger.AddMessage("you$ ", "git checkout main", false)
-    }
-    g.logger.AddMessage("you$ ", "git merge " + g.gridTree.commitHash, false)
-    g.logger.AddMessage("", "Updating " + g.gridTree.prev.commitHash, true)
-    g.logger.AddMessage("", "Fast-forward", true)
-    g.logger.AddMessage("", " board.bson | 1 +", true)
-    g.logger.AddMessage("", " 1 file changed, 1 insertions(+)", true)
-	if g.gridTree.generation == 0 {
-		// There's nothing to merge up into if we're first generation.
-		return
-	}
-	node := &g.gridTree
-	genOG := node.generation
-	for node.generation == genOG {
-		node.generation--
-		node = node.prev
+	tree := &g.gridTree
+	if g.merge != nil {
+		g.logger.AddMessage("", "error: Merging is not possible because you have unmerged files.", true)
+		logUnresolved(g)
+		return
 	}
+	branch := tree.head
+	target, ok := tree.upstream[branch]
+	if branch == "" || !ok {
+		g.logger.AddMessage("[!] ", "You cannot merge", false)
+		return
+	}
+	if workingTreeDirty(g) {
+		g.logger.AddMessage("", "error: Your local changes would be overwritten by merge.", true)
+		g.logger.AddMessage("", "Please commit your changes before you merge.", true)
+		return
+	}
+	g.logger.AddMessage("you$ ", "git checkout "+target, false)
+	tree.checkout(target)
+	// The target's board goes on the table, so the merge starts clean
+	setWorkingGrid(g, tree.headCommit().grid)
+	g.logger.AddMessage("you$ ", "git merge "+branch, false)
+	mergeBranch(g, branch, true)
+}
+
+// mergeBranch merges a branch into HEAD. drop deletes the branch once it's
+// merged, the way the m key tidies up after itself.
+func mergeBranch(g *Game, branch string, drop bool) {
+	tree := &g.gridTree
+	if g.merge != nil {
+		g.logger.AddMessage("", "error: Merging is not possible because you have unmerged files.", true)
+		logUnresolved(g)
+		return
+	}
+	theirs, ok := tree.refs[branch]
+	if !ok {
+		g.logger.AddMessage("", fmt.Sprintf("merge: %s - not something we can merge", branch), true)
+		return
+	}
+	if workingTreeDirty(g) {
+		g.logger.AddMessage("", "error: Your local changes would be overwritten by merge.", true)
+		g.logger.AddMessage("", "Please commit your changes before you merge.", true)
+		return
+	}
+	ours := tree.headHash()
+
+	if tree.isAncestor(theirs, ours) {
+		g.logger.AddMessage("", "Already up to date.", true)
+	} else if tree.isAncestor(ours, theirs) {
+		g.logger.AddMessage("", fmt.Sprintf("Updating %s..%s", ours[0:7], theirs[0:7]), true)
+		g.logger.AddMessage("", "Fast-forward", true)
+		logDiffStat(g, tree.commits[ours].grid, tree.commits[theirs].grid)
+		tree.moveHead(theirs)
+	} else {
+		base := tree.commits[tree.mergeBase(ours, theirs)]
+		ourGrid := tree.commits[ours].grid
+		merged, conflicts := mergeGrids(base.grid, ourGrid, tree.commits[theirs].grid)
+		g.logger.AddMessage("", "Auto-merging board.bson", true)
+		if len(conflicts) > 0 {
+			startConflictedMerge(g, branch, ours, theirs, merged, conflicts, drop)
+			return
+		}
+		if _, err := tree.commit(merged, []string{ours, theirs}, authorPlayer, fmt.Sprintf("Merge branch '%s'", branch)); err != nil {
+			g.logger.AddMessage("", "fatal: "+err.Error(), true)
+			return
+		}
+		g.logger.AddMessage("", "Merge made by the 'ort' strategy.", true)
+		logDiffStat(g, ourGrid, merged)
+	}
+	finishMerge(g, branch, theirs, drop)
 }
 
+// finishMerge checks out the result of a merge, first dropping the branch
+// that was merged in if asked to.
+func finishMerge(g *Game, branch, theirs string, drop bool) {
+	if drop {
+		g.gridTree.deleteBranch(branch)
+		g.logger.AddMessage("you$ ", "git branch -d "+branch, false)
+		g.logger.AddMessage("", fmt.Sprintf("Deleted branch %s (was %s).", branch, theirs[0:7]), true)
+	}
+	setWorkingGrid(g, g.gridTree.headCommit().grid)
+	g.autoScroll = true
+}
+
+// logDiffStat prints a "git diff --stat" style summary of a board change.
+func logDiffStat(g *Game, from, to TileGrid) {
+	n := changedTiles(from, to)
+	g.logger.AddMessage("", fmt.Sprintf(" board.bson | %d +-", n), true)
+	g.logger.AddMessage("", fmt.Sprintf(" 1 file changed, %d insertions(+), %d deletions(-)", n, n), true)
+}
+
+// nukeCurrentBranch is the r key: throw away the last commit, or the merge
+// in progress.
 func nukeCurrentBranch(g *Game) {
-	if g.gridTree.grid.SizeX == 0 || g.gridTree.prev == nil || g.gridTree.prev.grid.SizeX == 0 || g.gridTree.prev.prev == nil || g.gridTree.prev.prev.grid.SizeX == 0 {
-        g.logger.AddMessage("[!] ", "Can't revert", false)
+	if g.merge != nil {
+		g.logger.AddMessage("you$ ", "git merge --abort", false)
+		abortMerge(g)
 		return
 	}
-    g.logger.AddMessage("you$ ", "git reset --hard " + g.gridTree.prev.commitHash, false)
+	head := g.gridTree.headCommit()
+	if head == nil || len(head.parents) == 0 || len(g.gridTree.commits[head.parents[0]].parents) == 0 {
+		g.logger.AddMessage("[!] ", "Can't revert", false)
+		return
+	}
+	parent := head.parents[0]
+	g.logger.AddMessage("you$ ", "git reset --hard "+parent, false)
+	resetHard(g, parent)
+}
 
-    //node := g.gridTree.prev
-	//g.gridTree = *node
-    prevNode := g.gridTree.prev
-    prevNode.next = nil  // Break the circular reference
-    g.gridTree = *prevNode
-    g.selected.IsSelectedGrid = false
-    g.selected = nil
-    g.selected = &g.gridTree.grid
-    g.selected.IsSelectedGrid = true
+// resetHard points the checked out branch, or a detached HEAD, at another
+// commit and puts its board on the table. Going back to the empty board the
+// game starts from isn't allowed.
+func resetHard(g *Game, hash string) bool {
+	tree := &g.gridTree
+	if len(tree.commits[hash].parents) == 0 {
+		g.logger.AddMessage("[!] ", "Can't go back before the game started", false)
+		return false
+	}
+	if g.merge != nil {
+		// A hard reset throws the merge away too
+		g.merge = nil
+	}
+	tree.moveHead(hash)
+	setWorkingGrid(g, tree.headCommit().grid)
+	g.logger.AddMessage("", fmt.Sprintf("HEAD is now at %s", hash[0:7]), true)
+	return true
 }
 
 func gitSetup(g *Game) {
-	g.gridTree = GridTree{}
+	g.gridTree = NewGridTree()
 }
 
 func gitCurrentGrid(g *Game) TileGrid {
-	return g.gridTree.grid
+	return g.gridTree.headCommit().grid
 }
 
 func commitTestData(g *Game) error {
 	// Create initial commit on main
-	hash := gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 255, B: 255, G: 255, A: 1}), false, false)
+	hash := gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 255, B: 255, G: 255, A: 1}), "", false, false)
 
 	fmt.Println("Created a commit on master", hash)
 
 	// Add commits to feature1
-	hash = gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 255, B: 0, G: 0, A: 1}), true, false)
+	hash = gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 255, B: 0, G: 0, A: 1}), "", true, false)
 	fmt.Println("Created a commit on feature1", hash)
 
-	hash = gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 0, B: 0, G: 255, A: 1}), false, false)
+	hash = gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 0, B: 0, G: 255, A: 1}), "", false, false)
 	fmt.Println("Created a commit on feature1", hash)
 
 	// Add commit to feature2
-	hash = gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 0, B: 255, G: 0, A: 1}), true, false)
+	hash = gitCommitGrid(g, createGrid(4, 4, 9, 9, 4, 4, color.RGBA{R: 0, B: 255, G: 0, A: 1}), "", true, false)
 	fmt.Println("Created a commit on feature2", hash)
 
 	return nil
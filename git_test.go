@@ -0,0 +1,61 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func testGame(t *testing.T) *Game {
+	t.Helper()
+	g := &Game{logger: NewLogWindow()}
+	gitSetup(g)
+	if commitBoard(g, createGrid(0, 0, 4, 4, 160, 160, color.RGBA{}), "welcome to the game", false) == nil {
+		t.Fatal("couldn't make the first commit")
+	}
+	return g
+}
+
+// place puts a unit on the board on the table and commits it.
+func place(t *testing.T, g *Game, row, col int, name string) {
+	t.Helper()
+	g.selected.Tiles[row][col].Occupant = Unit{Name: name, HP: 1, StartingHP: 1, Present: true}
+	if commitBoard(g, *g.selected, "place "+name, false) == nil {
+		t.Fatalf("couldn't commit %s", name)
+	}
+}
+
+// TestMergeCurrentBranch is the m key after committing on a branch, both
+// when main can fast-forward and when it has moved on too.
+func TestMergeCurrentBranch(t *testing.T) {
+	for _, mainMoved := range []bool{false, true} {
+		g := testGame(t)
+		if !checkoutNewBranch(g, "feature") {
+			t.Fatal("couldn't start a branch")
+		}
+		place(t, g, 0, 0, "ours")
+		if mainMoved {
+			checkoutRev(g, "main")
+			place(t, g, 3, 3, "theirs")
+			checkoutRev(g, "feature")
+		}
+
+		mergeCurrentBranch(g)
+		tree := &g.gridTree
+		if tree.head != "main" {
+			t.Errorf("mainMoved=%v: HEAD is on %q, want main", mainMoved, tree.head)
+		}
+		if _, ok := tree.refs["feature"]; ok {
+			t.Errorf("mainMoved=%v: feature wasn't deleted", mainMoved)
+		}
+		if g.merge != nil || workingTreeDirty(g) {
+			t.Errorf("mainMoved=%v: merge left the working tree dirty", mainMoved)
+		}
+		head := tree.headCommit().grid
+		if !head.Tiles[0][0].Occupant.Present {
+			t.Errorf("mainMoved=%v: the branch's commit didn't make it into main", mainMoved)
+		}
+		if mainMoved && !head.Tiles[3][3].Occupant.Present {
+			t.Error("the merge lost main's own commit")
+		}
+	}
+}
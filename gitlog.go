@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// logGraph draws the history reachable from tips the way git log --graph
+// does, newest first: a column per line of history, "*" for a commit, "\"
+// where a merge's other parent branches off and "/" where two lines join
+// again. limit stops after that many commits, 0 shows them all.
+func (t *GridTree) logGraph(tips []string, oneline bool, limit int) []string {
+	shown := make(map[string]bool)
+	for _, tip := range tips {
+		for h := range t.ancestors(tip) {
+			shown[h] = true
+		}
+	}
+
+	var out []string
+	var cols []string
+	count := 0
+	for k := len(t.order) - 1; k >= 0; k-- {
+		c := t.order[k]
+		if !shown[c.hash] {
+			continue
+		}
+		if limit > 0 && count == limit {
+			break
+		}
+		count++
+
+		col := slices.Index(cols, c.hash)
+		if col < 0 {
+			cols = append(cols, c.hash)
+			col = len(cols) - 1
+		}
+		row := graphRow(len(cols))
+		row[2*col] = '*'
+		if oneline {
+			out = append(out, string(row)+t.onelineText(c))
+		} else {
+			out = append(out, string(row)+"commit "+c.hash[0:7]+t.decoration(c))
+		}
+
+		switch {
+		case len(c.parents) == 0:
+			// History starts here, everything to the right moves over
+			if col < len(cols)-1 {
+				out = append(out, shiftRow(len(cols), col, col))
+			}
+			cols = slices.Delete(cols, col, col+1)
+		default:
+			cols[col] = c.parents[0]
+			for n, p := range c.parents[1:] {
+				at := col + n + 1
+				row := graphRow(len(cols) + 1)
+				for i := at; i < len(cols)+1; i++ {
+					row[2*i] = ' '
+					row[2*i-1] = '\\'
+				}
+				out = append(out, strings.TrimRight(string(row), " "))
+				cols = slices.Insert(cols, at, p)
+			}
+		}
+
+		// Lines of history that reached the same commit join up
+		for {
+			from, into := -1, -1
+			for j := len(cols) - 1; j >= 0 && from < 0; j-- {
+				if i := slices.Index(cols, cols[j]); i < j {
+					from, into = j, i
+				}
+			}
+			if from < 0 {
+				break
+			}
+			out = append(out, shiftRow(len(cols), from, into))
+			cols = slices.Delete(cols, from, from+1)
+		}
+
+		if !oneline {
+			pad := strings.TrimRight(string(graphRow(len(cols))), " ")
+			if pad != "" {
+				pad += " "
+			}
+			out = append(out, pad+"Author: "+c.author)
+			out = append(out, pad+"Date:   "+c.date())
+			out = append(out, pad+"    "+c.message)
+			if c.summary != "" {
+				out = append(out, pad+"    "+c.summary)
+			}
+		}
+	}
+	return out
+}
+
+// graphRow is a line of the graph with every column carrying on straight.
+func graphRow(cols int) []rune {
+	return []rune(strings.Repeat("| ", cols))
+}
+
+// shiftRow draws column from ending, joining column into when it's further
+// left, and every column right of it moving one over to take its place.
+func shiftRow(cols, from, into int) string {
+	row := graphRow(cols)
+	row[2*from] = ' '
+	if into < from {
+		for i := 2*into + 1; i < 2*from-1; i++ {
+			if row[i] == ' ' {
+				row[i] = '_'
+			}
+		}
+		row[2*from-1] = '/'
+	}
+	for i := from + 1; i < cols; i++ {
+		row[2*i] = ' '
+		row[2*i-1] = '/'
+	}
+	return strings.TrimRight(string(row), " ")
+}
+
+// decoration lists what points at a commit, " (HEAD -> main, scout)" style.
+func (t *GridTree) decoration(c *commitNode) string {
+	var refs []string
+	if t.head == "" && t.detached == c.hash {
+		refs = append(refs, "HEAD")
+	}
+	for _, name := range t.branches() {
+		if t.refs[name] != c.hash {
+			continue
+		}
+		if name == t.head {
+			refs = append([]string{"HEAD -> " + name}, refs...)
+		} else {
+			refs = append(refs, name)
+		}
+	}
+	if len(refs) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(refs, ", ") + ")"
+}
+
+func (t *GridTree) onelineText(c *commitNode) string {
+	text := c.hash[0:7] + t.decoration(c) + " " + c.message
+	if c.summary != "" {
+		text += fmt.Sprintf(" [%s]", c.summary)
+	}
+	return text
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"container/heap"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gitRepo reads objects and refs straight out of an on-disk git repository,
+// loose objects and packfiles alike, without shelling out to git.
+type gitRepo struct {
+	dir   string
+	packs []*packFile
+}
+
+type packFile struct {
+	pack    *os.File
+	names   []byte // sorted 20 byte object names
+	offsets []int64
+}
+
+// openGitRepo opens either a bare repository or a work tree with a .git
+// directory in it.
+func openGitRepo(path string) (*gitRepo, error) {
+	dir := path
+	if st, err := os.Stat(filepath.Join(path, ".git")); err == nil && st.IsDir() {
+		dir = filepath.Join(path, ".git")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects")); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository", path)
+	}
+	repo := &gitRepo{dir: dir}
+	idxs, _ := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.idx"))
+	for _, idx := range idxs {
+		p, err := openPackFile(idx)
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+		repo.packs = append(repo.packs, p)
+	}
+	return repo, nil
+}
+
+func (r *gitRepo) Close() {
+	for _, p := range r.packs {
+		p.pack.Close()
+	}
+}
+
+// branches returns every branch and the commit it points at, from loose
+// refs and packed-refs both.
+func (r *gitRepo) branches() (map[string]string, error) {
+	refs := make(map[string]string)
+	if f, err := os.Open(filepath.Join(r.dir, "packed-refs")); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			hash, name, ok := strings.Cut(sc.Text(), " ")
+			if ok && strings.HasPrefix(name, "refs/heads/") {
+				refs[strings.TrimPrefix(name, "refs/heads/")] = hash
+			}
+		}
+		f.Close()
+	}
+	for name, hash := range refs {
+		if !validObjectName(hash) {
+			return nil, fmt.Errorf("packed ref refs/heads/%s: %q is not an object name", name, hash)
+		}
+	}
+	heads := filepath.Join(r.dir, "refs", "heads")
+	err := filepath.Walk(heads, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(heads, path)
+		hash := strings.TrimSpace(string(data))
+		if !validObjectName(hash) {
+			return fmt.Errorf("ref refs/heads/%s: %q is not an object name", filepath.ToSlash(name), hash)
+		}
+		refs[filepath.ToSlash(name)] = hash
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// headBranch is the branch HEAD points at, empty if it's detached.
+func (r *gitRepo) headBranch() string {
+	data, err := os.ReadFile(filepath.Join(r.dir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(data)), "ref: refs/heads/")
+}
+
+// validObjectName reports whether hash is a full SHA-1 object name, 40
+// lowercase hex digits.
+func validObjectName(hash string) bool {
+	if len(hash) != 40 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *gitRepo) readObject(hash string) (string, []byte, error) {
+	if !validObjectName(hash) {
+		return "", nil, fmt.Errorf("bad object name %q", hash)
+	}
+	path := filepath.Join(r.dir, "objects", hash[:2], hash[2:])
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		zr, err := zlib.NewReader(f)
+		if err != nil {
+			return "", nil, fmt.Errorf("object %s: %w", hash, err)
+		}
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			return "", nil, fmt.Errorf("object %s: %w", hash, err)
+		}
+		nul := bytes.IndexByte(raw, 0)
+		if nul < 0 {
+			return "", nil, fmt.Errorf("object %s is corrupt", hash)
+		}
+		kind, _, _ := strings.Cut(string(raw[:nul]), " ")
+		return kind, raw[nul+1:], nil
+	}
+	name, _ := hex.DecodeString(hash)
+	for _, p := range r.packs {
+		if off, ok := p.find(name); ok {
+			return r.readPacked(p, off)
+		}
+	}
+	return "", nil, fmt.Errorf("object %s not found", hash)
+}
+
+func openPackFile(idxPath string) (*packFile, error) {
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(idx[4:]) != 2 {
+		return nil, fmt.Errorf("%s: only version 2 pack indexes are supported", idxPath)
+	}
+	be := binary.BigEndian
+	n := int(be.Uint32(idx[8+255*4:]))
+	names := 8 + 256*4
+	offsets := names + n*20 + n*4
+	large := offsets + n*4
+	if len(idx) < large {
+		return nil, fmt.Errorf("%s: truncated", idxPath)
+	}
+	p := &packFile{names: idx[names : names+n*20], offsets: make([]int64, n)}
+	for i := range p.offsets {
+		off := be.Uint32(idx[offsets+i*4:])
+		if off&0x80000000 != 0 {
+			at := large + int(off&0x7fffffff)*8
+			if len(idx) < at+8 {
+				return nil, fmt.Errorf("%s: truncated", idxPath)
+			}
+			p.offsets[i] = int64(be.Uint64(idx[at:]))
+		} else {
+			p.offsets[i] = int64(off)
+		}
+	}
+	p.pack, err = os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *packFile) find(name []byte) (int64, bool) {
+	n := len(p.offsets)
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(p.names[i*20:i*20+20], name) >= 0
+	})
+	if i < n && bytes.Equal(p.names[i*20:i*20+20], name) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+var packKinds = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+const (
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// readPacked reads the object at off in a pack, resolving deltas.
+func (r *gitRepo) readPacked(p *packFile, off int64) (string, []byte, error) {
+	br := bufio.NewReader(io.NewSectionReader(p.pack, off, 1<<62))
+	b, err := br.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	kind := (b >> 4) & 7
+	for b&0x80 != 0 {
+		if b, err = br.ReadByte(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var baseKind string
+	var base []byte
+	switch kind {
+	case packOfsDelta:
+		b, err := br.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = br.ReadByte(); err != nil {
+				return "", nil, err
+			}
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if baseKind, base, err = r.readPacked(p, off-rel); err != nil {
+			return "", nil, err
+		}
+	case packRefDelta:
+		name := make([]byte, 20)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return "", nil, err
+		}
+		if baseKind, base, err = r.readObject(hex.EncodeToString(name)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+	if base == nil {
+		name, ok := packKinds[kind]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown pack object type %d", kind)
+		}
+		return name, data, nil
+	}
+	out, err := applyDelta(base, data)
+	return baseKind, out, err
+}
+
+// applyDelta rebuilds an object from its base and a git delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	varint := func() int {
+		v, shift := 0, 0
+		for len(delta) > 0 {
+			b := delta[0]
+			delta = delta[1:]
+			v |= int(b&0x7f) << shift
+			shift += 7
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return v
+	}
+	if varint() != len(base) {
+		return nil, fmt.Errorf("delta base has the wrong size")
+	}
+	out := make([]byte, 0, varint())
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 == 0 {
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, fmt.Errorf("bad delta insert")
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+		var off, size int
+		for i := 0; i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			if i < 4 {
+				off |= int(delta[0]) << (8 * i)
+			} else {
+				size |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if off+size > len(base) {
+			return nil, fmt.Errorf("delta copies past the end of its base")
+		}
+		out = append(out, base[off:off+size]...)
+	}
+	return out, nil
+}
+
+// repoCommit is the part of a commit level generation cares about.
+type repoCommit struct {
+	hash    string
+	parents []string
+	author  string
+	// Committer time, in seconds since the epoch
+	time int64
+}
+
+// readCommit reads a commit's header. ok is false when hash names some
+// other kind of object.
+func (r *gitRepo) readCommit(hash string) (c repoCommit, ok bool, err error) {
+	kind, body, err := r.readObject(hash)
+	if err != nil || kind != "commit" {
+		return repoCommit{}, false, err
+	}
+	c.hash = hash
+	header, _, _ := strings.Cut(string(body), "\n\n")
+	for _, line := range strings.Split(header, "\n") {
+		key, val, _ := strings.Cut(line, " ")
+		switch key {
+		case "parent":
+			c.parents = append(c.parents, val)
+		case "author":
+			c.author, _, _ = strings.Cut(val, " <")
+		case "committer":
+			// Name <email> seconds zone
+			if f := strings.Fields(val); len(f) >= 2 {
+				c.time, _ = strconv.ParseInt(f[len(f)-2], 10, 64)
+			}
+		}
+	}
+	return c, true, nil
+}
+
+// walkCommits reads the commits reachable from the given tips newest first,
+// by committer time the way git log orders them, stopping after limit
+// commits. Commits made at the same second go in hash order, so the same
+// repository always gives the same list.
+func (r *gitRepo) walkCommits(tips []string, limit int) ([]repoCommit, error) {
+	seen := make(map[string]bool)
+	queue := &commitQueue{}
+	push := func(hash string) error {
+		if seen[hash] {
+			return nil
+		}
+		seen[hash] = true
+		c, ok, err := r.readCommit(hash)
+		if ok {
+			heap.Push(queue, c)
+		}
+		return err
+	}
+	for _, tip := range tips {
+		if err := push(tip); err != nil {
+			return nil, err
+		}
+	}
+	var out []repoCommit
+	for queue.Len() > 0 && len(out) < limit {
+		c := heap.Pop(queue).(repoCommit)
+		out = append(out, c)
+		for _, p := range c.parents {
+			if err := push(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// commitQueue is a heap of commits, newest on top.
+type commitQueue []repoCommit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].time != q[j].time {
+		return q[i].time > q[j].time
+	}
+	return q[i].hash < q[j].hash
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(repoCommit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
 	"image"
 	"image/color"
+	"strings"
 
+	"github.com/gitjits/molniya/engine"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/colorm"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
 
@@ -16,49 +20,22 @@ import (
 var hexagonPng []byte
 var hexagonImg *ebiten.Image
 
-//go:embed lbj.png
-var LBJPNG []byte
-var LBJ_Img *ebiten.Image
-
-//go:embed newt.png
-var NewtPNG []byte
-var NewtImg *ebiten.Image
-
-//go:embed phonomancer.png
-var PhonomancerPNG []byte
-var PhonomancerImg *ebiten.Image
-
-//go:embed wizzy.png
-var WizzyPNG []byte
-var WizzyImg *ebiten.Image
-
-//go:embed centepede.png
-var CentepedePNG []byte
-var CentepedeImg *ebiten.Image
-
 func loadEmbeddedImage() (err error) {
 	hi, _, err := image.Decode(bytes.NewReader(hexagonPng))
-	lbj_img, _, err := image.Decode(bytes.NewReader(LBJPNG))
-	newt_img, _, err := image.Decode(bytes.NewReader(NewtPNG))
-	phonomancer_img, _, err := image.Decode(bytes.NewReader(PhonomancerPNG))
-	wizzy_img, _, err := image.Decode(bytes.NewReader(WizzyPNG))
-	centepede_img, _, err := image.Decode(bytes.NewReader(CentepedePNG))
 	if err != nil {
 		return err
 	}
 	hexagonImg = ebiten.NewImageFromImage(hi)
-	LBJ_Img = ebiten.NewImageFromImage(lbj_img)
-	NewtImg = ebiten.NewImageFromImage(newt_img)
-	PhonomancerImg = ebiten.NewImageFromImage(phonomancer_img)
-	WizzyImg = ebiten.NewImageFromImage(wizzy_img)
-	CentepedeImg = ebiten.NewImageFromImage(centepede_img)
+	for name, img := range unitImages {
+		unitSprites[name] = ebiten.NewImageFromImage(img)
+	}
 	return nil
 }
 
 type Tile struct {
 	Selected bool
 	Color    color.RGBA
-	occupant Unit
+	Occupant Unit
 }
 
 type vec2i struct {
@@ -101,7 +78,7 @@ func (grid *TileGrid) Equals(ng TileGrid) bool {
 	}
 	for j := 0; j < len(grid.Tiles); j++ {
 		for i := 0; i < len(grid.Tiles[j]); i++ {
-			if grid.Tiles[j][i].occupant != ng.Tiles[j][i].occupant {
+			if grid.Tiles[j][i].Occupant != ng.Tiles[j][i].Occupant {
 				return false
 			}
 			if grid.Tiles[j][i].Color != ng.Tiles[j][i].Color {
@@ -128,96 +105,258 @@ func (grid *TileGrid) clearSelection() {
 	grid.selectedCells[1] = vec2i{}
 }
 
-func (grid *TileGrid) applyMove(g *Game) {
+func toPos(v vec2i) engine.Pos {
+	return engine.Pos{Row: v.x, Col: v.y}
+}
+
+func fromPos(p engine.Pos) vec2i {
+	return vec2i{x: p.Row, y: p.Col, valid: true}
+}
+
+// State is the position on the board, as the engine sees it.
+func (grid *TileGrid) State() engine.State {
+	s := engine.NewState(len(grid.Tiles), 0)
+	for j := range grid.Tiles {
+		s.Cells[j] = make([]Unit, len(grid.Tiles[j]))
+		for i, tile := range grid.Tiles[j] {
+			s.Cells[j][i] = tile.Occupant
+		}
+	}
+	return s
+}
+
+// setState puts the units of an engine position back on the board.
+func (grid *TileGrid) setState(s engine.State) {
+	for j := range grid.Tiles {
+		for i, tile := range grid.Tiles[j] {
+			tile.Occupant = s.Cells[j][i]
+		}
+	}
+}
+
+// applyMove plays the move, or ability, the two selected tiles make up, and
+// returns what happened.
+func (grid *TileGrid) applyMove(g *Game) []engine.Event {
 	if !grid.selectedCells[1].valid {
-		return
+		return nil
 	}
 
 	// User wants to make a move!
 	pos1 := grid.selectedCells[0]
 	pos2 := grid.selectedCells[1]
-	source := grid.Tiles[pos1.x][pos1.y]
-	target := grid.Tiles[pos2.x][pos2.y]
 
-	if pos1.x == pos2.x && pos1.y == pos2.y || !source.occupant.Present {
+	// The move is over, selection vanishes no matter what
+	grid.clearSelection()
+	if pos1.x == pos2.x && pos1.y == pos2.y && !g.aiming || !grid.Tiles[pos1.x][pos1.y].Occupant.Present {
 		// Source is the same as target, cancel the move
-		grid.clearSelection()
-		return
+		g.aiming = false
+		return nil
+	}
+
+	ability := g.aiming
+	if reason := canAct(g, grid.Tiles[pos1.x][pos1.y].Occupant, ability); reason != "" {
+		g.aiming = false
+		g.logger.AddMessage("[!] ", reason, false)
+		return nil
 	}
 
-	if !source.occupant.Present {
-		// No one's here, they can just move.
-		*target = *source
-		source.occupant = Unit{Present: false}
-		source.Color = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	s := grid.State()
+	s.Rand = g.rng.Uint64()
+	var next engine.State
+	var events []engine.Event
+	if g.aiming {
+		g.aiming = false
+		next, events = engine.ApplyAbility(s, engine.Cast{From: toPos(pos1), Target: toPos(pos2)})
 	} else {
-		source.occupant.attackEnemy(&target.occupant, g)
-		if target.occupant.HP <= 0 {
-			// Attacker won, move into the cell
-			target.occupant = source.occupant
-			source.occupant = Unit{Present: false}
-		} else if source.occupant.HP <= 0 {
-			// Attacker lost, delete them
-			source.occupant = Unit{Present: false}
-		}
+		next, events = engine.ApplyMove(s, engine.Move{From: toPos(pos1), To: toPos(pos2)})
 	}
+	grid.setState(next)
+	logEvents(g, events)
+	spendAP(grid, events, actionCost(ability))
+	return events
+}
 
-	// The move is over, selection vanishes no matter what
-	grid.clearSelection()
-	g.botWaitPeriod = 100
+// useAbility arms the ability of the unit the player has picked, or puts it
+// away again. Abilities on the unit itself go off straight away, the rest
+// wait for a target.
+func (grid *TileGrid) useAbility(g *Game) {
+	if g.aiming {
+		g.aiming = false
+		g.logger.AddMessage("[!] ", "Never mind", false)
+		return
+	}
+	pos := grid.selectedCells[0]
+	if !pos.valid || !grid.Tiles[pos.x][pos.y].Occupant.Present || grid.Tiles[pos.x][pos.y].Occupant.BotUnit {
+		g.logger.AddMessage("[!] ", "Select one of your units first", false)
+		return
+	}
+	unit := grid.Tiles[pos.x][pos.y].Occupant
+	ability, ok := unit.Active()
+	if !ok {
+		g.logger.AddMessage("[!] ", unit.Name+" has no ability to use", false)
+		return
+	}
+	g.aiming = true
+	if ability.Targeting == engine.Self {
+		grid.selectedCells[1] = pos
+		grid.applyMove(g)
+		return
+	}
+	g.logger.AddMessage("[!] ", fmt.Sprintf("%s: click where to %s", unit.Name, ability.Name), false)
 }
 
-func (grid *TileGrid) makeBotMove(g *Game) {
-	for j := 0; j < len(grid.Tiles); j++ {
-		for i := 0; i < len(grid.Tiles[j]); i++ {
-			var tile *Tile = grid.Tiles[j][i]
+// botPlan is the action the bot settled on, and the board it was thinking
+// about.
+type botPlan struct {
+	board  engine.State
+	result engine.SearchResult
+	ok     bool
+}
 
-			// Skip empty tiles
-			if !tile.occupant.Present {
-				continue
-			}
+// botMayPlay reports whether the bot's unit has the points for an action.
+func botMayPlay(s engine.State, a engine.Action) bool {
+	return s.At(a.From).AP >= actionCost(a.Ability)
+}
 
-			if tile.occupant.BotUnit {
-				g.selected.selectedCells[0] = vec2i{x: j, y: i, valid: true}
-			} else {
-				g.selected.selectedCells[1] = vec2i{x: j, y: i, valid: true}
-			}
+// startBotMove has the bot think about its move on a copy of the board.
+// The search runs off the game loop, for as long as -think allows and as
+// deep as the difficulty allows, and finishBotMove picks up the answer.
+func startBotMove(g *Game) {
+	board := g.selected.State()
+	budget := g.thinkTime
+	opts := engine.SearchOptions{Depth: g.difficulty.depth, Personalities: factionPersonalities, Allowed: botMayPlay}
+	plan := make(chan botPlan, 1)
+	g.botPlan = plan
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), budget)
+		defer cancel()
+		res, ok := engine.Search(ctx, board, engine.Bot, opts)
+		plan <- botPlan{board: board, result: res, ok: ok}
+	}()
+}
 
+// finishBotMove plays the bot's move once it's done thinking. If the board
+// changed under it in the meantime, it thinks again.
+func finishBotMove(g *Game) {
+	var plan botPlan
+	select {
+	case plan = <-g.botPlan:
+		g.botPlan = nil
+	default:
+		return
+	}
+	grid := g.selected
+	if !plan.board.Equal(grid.State()) {
+		startBotMove(g)
+		return
+	}
+	if !plan.ok {
+		g.logger.AddMessage("[!]", "The enemy is holding its ground", false)
+		endEnemyTurn(g)
+		return
+	}
+	action := plan.result.Action
+	if g.rng.Float64() < g.difficulty.blunder {
+		// Not its finest hour
+		var actions []engine.Action
+		for _, a := range plan.board.Actions(engine.Bot) {
+			if botMayPlay(plan.board, a) {
+				actions = append(actions, a)
+			}
 		}
+		action = actions[g.rng.IntN(len(actions))]
+	}
+	if wantsToGamble(g, action) {
+		g.logger.AddMessage("[!]", "The enemy is trying its luck on a branch!", false)
+		if enemyGamble(g, action) {
+			botActed(g)
+		} else {
+			endEnemyTurn(g)
+		}
+		return
+	}
+	grid.selectedCells[0] = fromPos(action.From)
+	grid.selectedCells[1] = fromPos(action.To)
+	g.aiming = action.Ability
+	if action.Ability {
+		g.logger.AddMessage("[!]", "The enemy is up to something!", false)
+	} else {
+		g.logger.AddMessage("[!]", "The enemy has committed an act of war!", false)
 	}
-	g.logger.AddMessage("[!]", "The enemy has committed an act of war!", false)
 	grid.applyMove(g)
+	botActed(g)
 }
 
-func drawGridTree(g *Game, tree *GridTree, screen *ebiten.Image, offsetY, offsetX int) {
-	// Draw current node
-	if tree.grid.SizeX == 0 {
-		fmt.Println("GRID IS NIL", tree.grid)
-		return
-	}
+// Spacing of commit thumbnails in the tree view
+const (
+	treeColumnWidth = 135
+	treeRowHeight   = 125
+	// Where the first branch's row starts, and how far a frame of scrolling
+	// moves the rows
+	treeTop        = 50
+	treeScrollStep = 8
+)
 
-	// Handle selected grid
-	if tree.grid.IsSelectedGrid {
-		// Draw main selected grid in center
+// scrollTreeRows scrolls the tree view up and down, with the arrow keys or
+// the mouse wheel, once there are more branches than rows fit on screen.
+// Checking something out brings its row into view.
+func scrollTreeRows(g *Game) {
+	lanes := 0
+	for _, c := range g.gridTree.order {
+		lanes = max(lanes, c.lane+1)
+	}
+	_, wheel := ebiten.Wheel()
+	typing := g.console.focused
+	switch {
+	case wheel > 0 || !typing && ebiten.IsKeyPressed(ebiten.KeyArrowUp):
+		g.scrollY -= treeScrollStep
+	case wheel < 0 || !typing && ebiten.IsKeyPressed(ebiten.KeyArrowDown):
+		g.scrollY += treeScrollStep
+	case g.autoScroll:
+		if head := g.gridTree.headCommit(); head != nil {
+			top := treeTop + head.lane*treeRowHeight - g.scrollY
+			if top < treeTop {
+				g.scrollY -= treeTop - top
+			} else if top+treeRowHeight > screenHeight {
+				g.scrollY += top + treeRowHeight - screenHeight
+			}
+		}
+	}
+	g.scrollY = min(max(g.scrollY, 0), max(0, treeTop+lanes*treeRowHeight-screenHeight))
+}
 
-		// Draw small version in tree
-		faux := createGrid(offsetX, offsetY+tree.generation*125, tree.grid.SizeX, tree.grid.SizeY, 115, 123, tree.grid.Color)
-		faux.Tiles = tree.grid.Tiles
-		drawGrid(faux, screen, g)
-	} else {
-		tree.grid.X = offsetX
-		tree.grid.Y = offsetY + tree.generation*125
-		tree.grid.BoundsX = 115
-		tree.grid.BoundsY = 123
+func drawGridTree(g *Game, tree *GridTree, screen *ebiten.Image, offsetY, offsetX int) {
+	thumbPos := func(c *commitNode) (int, int) {
+		return offsetX + c.index*treeColumnWidth, offsetY + c.lane*treeRowHeight
 	}
 
-	tree.grid.Update(g)
-	drawGrid(tree.grid, screen, g)
+	// Edges go down first so the thumbnails cover their ends
+	for _, c := range tree.order {
+		cx, cy := thumbPos(c)
+		for _, p := range c.parents {
+			px, py := thumbPos(tree.commits[p])
+			vector.StrokeLine(screen, float32(px+57), float32(py+61), float32(cx+57), float32(cy+61), 2, color.RGBA{0xAA, 0xAA, 0xAA, 0xFF}, false)
+		}
+	}
 
-	// Continue main branch
-	if tree.next != nil && tree.next.grid.SizeX != 0 {
-		drawGridTree(g, tree.next, screen, offsetY, offsetX+135)
+	head := tree.headHash()
+	mx, my := ebiten.CursorPosition()
+	var hover *commitNode
+	for _, c := range tree.order {
+		thumb := c.grid
+		thumb.X, thumb.Y = thumbPos(c)
+		thumb.BoundsX = 115
+		thumb.BoundsY = 123
+		drawGrid(thumb, screen, g)
+		r := tileRadius(&thumb)
+		if c.hash == head {
+			vector.StrokeRect(screen, float32(thumb.X-r/2), float32(thumb.Y), float32(thumb.BoundsX+r), float32(thumb.BoundsY+r), 2, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, false)
+		}
+		if mx >= thumb.X-r/2 && mx < thumb.X-r/2+thumb.BoundsX+r && my >= thumb.Y && my < thumb.Y+thumb.BoundsY+r {
+			hover = c
+		}
 	}
+
 	if g.selected != nil && !g.hidden {
 		g.selected.X = screenWidth/2 - 150
 		g.selected.Y = screenHeight/2 - 150
@@ -228,23 +367,100 @@ func drawGridTree(g *Game, tree *GridTree, screen *ebiten.Image, offsetY, offset
 			r := tileRadius(g.selected)
 			vector.DrawFilledRect(screen, float32(g.selected.X-r/2), float32(g.selected.Y), float32(g.selected.BoundsX+r), float32(g.selected.BoundsY+r), color.RGBA{0, 0, 0, 100}, false)
 			drawGrid(*g.selected, screen, g)
+			if mx >= g.selected.X-r/2 && mx < g.selected.X-r/2+g.selected.BoundsX+r && my >= g.selected.Y && my < g.selected.Y+g.selected.BoundsY+r {
+				// The board is on top of whatever thumbnail is under it
+				hover = nil
+			}
 		}
 	}
+	g.hoveredCommit = ""
+	if hover != nil {
+		g.hoveredCommit = hover.hash
+		drawCommitInfo(screen, hover, mx, my)
+	}
+}
+
+// drawCommitInfo shows a commit the way git show would, next to the cursor.
+func drawCommitInfo(screen *ebiten.Image, c *commitNode, mx, my int) {
+	lines := []string{
+		"commit " + c.hash[0:7],
+		"Author: " + c.author,
+		"Date:   " + c.date(),
+		"",
+		"    " + c.message,
+	}
+	if c.summary != "" {
+		lines = append(lines, "")
+		for _, part := range strings.Split(c.summary, ", ") {
+			lines = append(lines, "    "+part)
+		}
+	}
+	lines = append(lines, "", "(click to check out)")
+	width := 0
+	for _, l := range lines {
+		width = max(width, len(l))
+	}
+	// The debug font is 6x16
+	w, h := width*6+8, len(lines)*16+8
+	x, y := min(mx+12, screenWidth-w), min(my+12, screenHeight-h)
+	vector.DrawFilledRect(screen, float32(x), float32(y), float32(w), float32(h), color.RGBA{0, 0, 0, 0xD0}, false)
+	ebitenutil.DebugPrintAt(screen, strings.Join(lines, "\n"), x+4, y+4)
+}
+
+// rangeOverlay is what the unit the player has picked, or failing that the
+// one under the cursor, could do this turn.
+func rangeOverlay(grid *TileGrid, g *Game) engine.Reach {
+	focus := grid.selectedCells[0]
+	if !focus.valid || !grid.Tiles[focus.x][focus.y].Occupant.Present {
+		focus = g.hovered
+	}
+	if !focus.valid || focus.x >= len(grid.Tiles) || focus.y >= len(grid.Tiles[focus.x]) {
+		return engine.Reach{}
+	}
+	return grid.State().Reachable(toPos(focus))
+}
+
+// castOverlay is where the picked unit could aim its ability, and what it
+// would hit aimed at the tile under the cursor.
+func castOverlay(grid *TileGrid, g *Game) (map[engine.Pos]bool, map[engine.Pos]bool) {
+	from := grid.selectedCells[0]
+	if !from.valid {
+		return nil, nil
+	}
+	s := grid.State()
+	castable := make(map[engine.Pos]bool)
+	for _, p := range s.CastTargets(toPos(from)) {
+		castable[p] = true
+	}
+	blast := make(map[engine.Pos]bool)
+	if ability, ok := s.At(toPos(from)).Active(); ok && g.hovered.valid && castable[toPos(g.hovered)] {
+		for _, p := range s.Blast(ability, toPos(g.hovered)) {
+			blast[p] = true
+		}
+	}
+	return castable, blast
 }
 
 func drawGrid(grid TileGrid, screen *ebiten.Image, g *Game) {
 	r := tileRadius(&grid)
-	p1Dead := true
-	p2Dead := true
-	cil := false
+	var reach engine.Reach
+	var castable, blast map[engine.Pos]bool
+	if grid.IsSelectedGrid && g.merge == nil && !g.stop {
+		if g.aiming {
+			castable, blast = castOverlay(&grid, g)
+		} else {
+			reach = rangeOverlay(&grid, g)
+		}
+	}
 	for j := 0; j < len(grid.Tiles); j++ {
 		for i := 0; i < len(grid.Tiles[j]); i++ {
 			tile := grid.Tiles[j][i]
+			conflicted := grid.IsSelectedGrid && g.merge != nil && g.merge.isUnresolved(vec2i{x: j, y: i})
 			var R int
 			var B int
 			var G int
-			if tile.occupant.Present {
-				if tile.occupant.BotUnit {
+			if tile.Occupant.Present {
+				if tile.Occupant.BotUnit {
 					R = 255
 					G = 0
 					B = 0
@@ -258,6 +474,19 @@ func drawGrid(grid TileGrid, screen *ebiten.Image, g *Game) {
 				G = int(tile.Color.G)
 				B = int(tile.Color.B)
 			}
+			if _, ok := reach.Moves[engine.Pos{Row: j, Col: i}]; ok {
+				// Somewhere it can walk to, washed out towards blue
+				R, G, B = (R+100)/2, (G+180)/2, (B+255)/2
+			} else if _, ok := reach.Attacks[engine.Pos{Row: j, Col: i}]; ok {
+				// An enemy it can reach and attack
+				R, G, B = 255, 220, 0
+			}
+			if blast[engine.Pos{Row: j, Col: i}] {
+				// Caught up in the ability about to be used
+				R, G, B = 200, 0, 255
+			} else if castable[engine.Pos{Row: j, Col: i}] {
+				R, G, B = (R+200)/2, G/2, (B+255)/2
+			}
 			Xpos, Ypos := tileScreenPos(&grid, i, j)
 			op := &colorm.DrawImageOptions{}
 			scale := float64(float64(r) * 2.0 / 256.0)
@@ -274,47 +503,18 @@ func drawGrid(grid TileGrid, screen *ebiten.Image, g *Game) {
 			cm.Scale(r, g, b, a)
 			op.Filter = ebiten.FilterNearest
 			colorm.DrawImage(screen, hexagonImg, cm, op)
+			if conflicted {
+				vector.StrokeCircle(screen, float32(Xpos), float32(Ypos), float32(r)*0.8, 2, color.RGBA{0xFF, 0x00, 0xFF, 0xFF}, false)
+			}
 
 			unitOptions := &ebiten.DrawImageOptions{}
 			unitOptions.GeoM = op.GeoM
 			unitOptions.Filter = ebiten.FilterNearest
-			if tile.occupant.Name == UNIT_LBJ.Name {
-				screen.DrawImage(LBJ_Img, unitOptions)
-				p1Dead = false
-				cil = true
-			}
-			if tile.occupant.Name == UNIT_NEWTHANDS.Name {
-				screen.DrawImage(NewtImg, unitOptions)
-				p1Dead = false
-				cil = true
-			}
-			if tile.occupant.Name == UNIT_WIZZY.Name {
-				screen.DrawImage(WizzyImg, unitOptions)
-				p2Dead = false
-				cil = true
-			}
-			if tile.occupant.Name == UNIT_WING_CENTIPEDE.Name {
-				screen.DrawImage(CentepedeImg, unitOptions)
-				p2Dead = false
-				cil = true
-			}
-			if tile.occupant.Name == UNIT_PHONOMANCER.Name {
-				screen.DrawImage(PhonomancerImg, unitOptions)
-				p1Dead = false
-				cil = true
+			if sprite, ok := unitSprites[tile.Occupant.Name]; ok && tile.Occupant.Present {
+				screen.DrawImage(sprite, unitOptions)
 			}
 		}
 	}
-	if p2Dead && !g.stop && cil && g.gridTree.generation == 0 {
-		g.logger.AddMessage("you$ ", "git push origin main", false)
-		g.logger.AddMessage("", "You win!", false)
-		g.gridTree.generation = -3
-        g.stop = true
-	} else if p1Dead && !g.stop && cil && g.gridTree.generation == 0 {
-		g.logger.AddMessage("you$ ", "sudo rm -rf / --no-preserve-root", false)
-		g.logger.AddMessage("", "whoops. it's over", false)
-        g.stop = true
-	}
 	vector.StrokeRect(screen, float32(grid.X-r/2), float32(grid.Y), float32(grid.BoundsX+r), float32(grid.BoundsY+r), 1, color.RGBA{R: 0, G: 0, B: 0, A: 255}, false)
 }
 
@@ -406,7 +606,8 @@ func (grid *TileGrid) Update(g *Game) {
 				X, Y := tileScreenPos(grid, i, j)
 				r := tileRadius(grid)
 				if mx <= X+r && mx >= X-r && my <= Y+r && my >= Y-r {
-					g.infoSprite = grid.Tiles[j][i].occupant
+					g.infoSprite = grid.Tiles[j][i].Occupant
+					g.hovered = vec2i{x: j, y: i, valid: true}
 				}
 			}
 		}
@@ -427,6 +628,14 @@ func (grid *TileGrid) Update(g *Game) {
 			grid.ClickMap["clickTile"] = false
 		}
 
-		grid.applyMove(g)
+		if g.merge != nil {
+			// Resolving conflicts only ever needs the last tile clicked
+			if grid.selectedCells[1].valid {
+				grid.selectedCells[0] = grid.selectedCells[1]
+				grid.selectedCells[1] = vec2i{}
+			}
+		} else {
+			grid.applyMove(g)
+		}
 	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/gitjits/molniya/engine"
+)
+
+// TestHexLayoutMatchesScreen checks the engine's idea of which tiles touch
+// against where tileScreenPos draws them: neighbours are the tiles whose
+// centres are about one tile apart, and nothing else is that close.
+func TestHexLayoutMatchesScreen(t *testing.T) {
+	grid := createGrid(0, 0, 8, 8, 320, 320, color.RGBA{})
+	r := tileRadius(&grid)
+	screen := func(p engine.Pos) (int, int) {
+		// Tiles[j][i] is drawn at tileScreenPos(i, j)
+		return tileScreenPos(&grid, p.Col, p.Row)
+	}
+	s := grid.State()
+	for _, a := range s.Within(engine.Pos{}, 100) {
+		ax, ay := screen(a)
+		for _, b := range s.Within(engine.Pos{}, 100) {
+			if a == b {
+				continue
+			}
+			bx, by := screen(b)
+			dx, dy := bx-ax, by-ay
+			near := dx*dx+dy*dy <= (5*r/2)*(5*r/2)
+			if touching := engine.Distance(a, b) == 1; touching != near {
+				t.Errorf("%v and %v are drawn %d,%d apart, but Distance is %d", a, b, dx, dy, engine.Distance(a, b))
+			}
+		}
+	}
+
+	// Every step of a path has to be to a tile drawn next to the last
+	path, ok := s.FindPath(engine.Pos{Row: 0, Col: 0}, engine.Pos{Row: 7, Col: 7})
+	if !ok {
+		t.Fatal("no path across an empty board")
+	}
+	for i := 1; i < len(path); i++ {
+		ax, ay := screen(path[i-1])
+		bx, by := screen(path[i])
+		if dx, dy := bx-ax, by-ay; dx*dx+dy*dy > (5*r/2)*(5*r/2) {
+			t.Errorf("path steps from %v to %v, drawn %d,%d apart", path[i-1], path[i], dx, dy)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"math/bits"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/gitjits/molniya/engine"
+)
+
+// How many commits of a repository are read to build a level from it
+const levelCommitLimit = 5000
+
+// repoLevel is an opening position generated from a git repository.
+type repoLevel struct {
+	grid     TileGrid
+	branches []string
+	commits  int
+	authors  []string
+}
+
+// armySize turns a commit count into a number of units, growing with the
+// log of the count so huge repositories stay playable.
+func armySize(commits int) int {
+	return min(max(bits.Len(uint(commits)), 2), 8)
+}
+
+// loadLevel reads the history of the repository at path and builds a level
+// from it. Every branch besides the checked out one becomes a branch in the
+// game and makes the board bigger. The most prolific author's commits size
+// the player's army and everyone else's size the enemy's. Units are placed
+// by an RNG seeded from the tip commit, so a repository always plays the
+// same.
+func loadLevel(path string) (*repoLevel, error) {
+	repo, err := openGitRepo(path)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+	refs, err := repo.branches()
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%s has no branches", path)
+	}
+	head := repo.headBranch()
+	if _, ok := refs[head]; !ok {
+		head = sortedKeys(refs)[0]
+	}
+	tips := []string{refs[head]}
+	level := &repoLevel{}
+	for _, name := range sortedKeys(refs) {
+		if name != head {
+			level.branches = append(level.branches, name)
+			tips = append(tips, refs[name])
+		}
+	}
+	commits, err := repo.walkCommits(tips, levelCommitLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("%s has no commits on its branches", path)
+	}
+	level.commits = len(commits)
+
+	perAuthor := make(map[string]int)
+	for _, c := range commits {
+		perAuthor[c.author]++
+	}
+	level.authors = sortedKeys(perAuthor)
+	sort.SliceStable(level.authors, func(a, b int) bool {
+		return perAuthor[level.authors[a]] > perAuthor[level.authors[b]]
+	})
+	players := perAuthor[level.authors[0]]
+	enemies := len(commits) - players
+	if enemies == 0 {
+		// Working alone, you fight your own past
+		enemies = len(commits) / 2
+	}
+
+	seed, _ := hex.DecodeString(refs[head])
+	if len(seed) < 16 {
+		return nil, fmt.Errorf("branch %s points at a bad commit", head)
+	}
+	rng := rand.New(rand.NewPCG(binary.BigEndian.Uint64(seed), binary.BigEndian.Uint64(seed[8:])))
+
+	size := min(9+len(level.branches)/2, 11)
+	level.grid = createGrid(0, 0, size, size, screenWidth/2, screenHeight/2, color.RGBA{R: 255, B: 255, G: 255, A: 200})
+	placeArmy(&level.grid, rng, unitsOf(engine.Player), armySize(players), size-3)
+	placeArmy(&level.grid, rng, unitsOf(engine.Bot), armySize(enemies), 0)
+	return level, nil
+}
+
+// placeArmy drops n units, cycling through kinds, on free tiles in the three
+// columns starting at col.
+func placeArmy(grid *TileGrid, rng *rand.Rand, kinds []Unit, n int, col int) {
+	for k := 0; k < n; k++ {
+		for {
+			x := rng.IntN(grid.SizeX)
+			y := col + rng.IntN(3)
+			if !grid.Tiles[x][y].Occupant.Present {
+				grid.Tiles[x][y].Occupant = kinds[k%len(kinds)]
+				break
+			}
+		}
+	}
+}
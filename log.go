@@ -71,7 +71,8 @@ func (l *LogWindow) Draw(screen *ebiten.Image) {
         ebitenutil.DrawRect(screen, sx, sy+y, width, 1, col)
     }
 
-    visibleLines := int(height / l.lineHeight)
+    // The last line is the console's
+    visibleLines := int(height / l.lineHeight) - 1
     startIdx := len(l.messages)
     if startIdx > visibleLines {
         startIdx = len(l.messages) - visibleLines
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"time"
 
 	"image/color"
 
+	"github.com/gitjits/molniya/engine"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 )
@@ -25,18 +29,54 @@ type Game struct {
 	inited bool
 
 	logger              *LogWindow
+	console             Console
 	scrollX             int
+	scrollY             int
 	autoScroll          bool
 	CPressedLastFrame   bool
 	BPressedLastFrame   bool
 	MPressedLastFrame   bool
 	RPressedLastFrame   bool
 	ESCPressedLastFrame bool
+	OPressedLastFrame   bool
+	TPressedLastFrame   bool
+	FPressedLastFrame   bool
+	F5PressedLastFrame  bool
+	F9PressedLastFrame  bool
+	XPressedLastFrame   bool
+	APressedLastFrame   bool
+	EPressedLastFrame   bool
+	ClickedLastFrame    bool
 
 	infoSprite    Unit
+	hovered       vec2i
+	hoveredCommit string
+	aiming        bool
 	hidden        bool
 	stop          bool
 	botWaitPeriod int
+	turn          turnState
+
+	// The bot thinks for up to thinkTime, and its move shows up on botPlan
+	thinkTime time.Duration
+	botPlan   chan botPlan
+	// Lost fights the enemy can still take back
+	botGambles int
+	difficulty difficulty
+	rules      gameRules
+
+	// Set while a merge is stopped on conflicts
+	merge *mergeState
+
+	// Every random roll in the game comes from here, so saving the PCG
+	// state saves the dice
+	pcg *rand.PCG
+	rng *rand.Rand
+
+	savePath   string
+	resume     bool
+	exportPath string
+	levelPath  string
 }
 
 func (g *Game) init() {
@@ -45,29 +85,55 @@ func (g *Game) init() {
 	}()
 	g.scrollX = 50
 	g.botWaitPeriod = -1
+	g.botGambles = g.difficulty.gambles
+	g.turn = turnState{number: 1}
+	g.pcg = rand.NewPCG(uint64(time.Now().UnixNano()), uint64(time.Now().Unix()))
+	g.rng = rand.New(g.pcg)
 
 	err := loadEmbeddedImage()
 	if err != nil {
 		panic(err)
 	}
 
-	g.gridTree = GridTree{}
+	g.gridTree = NewGridTree()
 
 	g.logger = NewLogWindow()
 
+	size := 9
+	var level *repoLevel
+	var levelErr error
+	if g.levelPath != "" {
+		level, levelErr = loadLevel(g.levelPath)
+		if level != nil {
+			size = level.grid.SizeX
+		}
+	}
+
 	// Create basic test data in the repo
-	g.grid = createGrid(0, 0, 9, 9, screenWidth/2, screenHeight/2, color.RGBA{R: 255, B: 255, G: 255, A: 200})
+	g.grid = createGrid(0, 0, size, size, screenWidth/2, screenHeight/2, color.RGBA{R: 255, B: 255, G: 255, A: 200})
 	g.grid.Update(g)
-	gitCommitGrid(g, g.grid, false, true)
-	g.selected = &g.grid
-	g.selected.IsSelectedGrid = true
+	gitCommitGrid(g, g.grid, "", false, true)
 
-	g.grid = createGrid(0, 0, 9, 9, screenWidth/2, screenHeight/2, color.RGBA{R: 255, B: 255, G: 255, A: 200})
+	if level != nil {
+		g.grid = level.grid
+	} else {
+		g.grid = createGrid(0, 0, size, size, screenWidth/2, screenHeight/2, color.RGBA{R: 255, B: 255, G: 255, A: 200})
+		randomPopulate(&g.grid, g.rng)
+	}
 	g.grid.Update(g)
-	gitCommitGrid(g, g.grid, false, true)
-	g.selected = &g.grid
-	g.selected.IsSelectedGrid = true
-	randomPopulate(g.selected)
+	gitCommitGrid(g, g.grid, "", false, true)
+	var branchErrs []error
+	if level != nil {
+		for _, name := range level.branches {
+			if branchLimitReached(g) {
+				branchErrs = append(branchErrs, fmt.Errorf("%s: maximum allowed branches (%d)", name, g.rules.maxBranches))
+				continue
+			}
+			if err := g.gridTree.createBranch(name); err != nil {
+				branchErrs = append(branchErrs, err)
+			}
+		}
+	}
 	//commitTestData(g)
 
 	// We need a simplified commit tree to efficiently render it
@@ -85,9 +151,21 @@ func (g *Game) init() {
 	g.logger.AddMessage("", "", false)
 	g.logger.AddMessage("", "", false)
 	g.logger.AddMessage("", "", false)
-	g.logger.AddMessage("ur_enemy$ ", "git init", false)
+	if level != nil {
+		g.logger.AddMessage("ur_enemy$ ", "git clone "+g.levelPath, false)
+		g.logger.AddMessage("", fmt.Sprintf("%d commits by %d authors, %d branches", level.commits, len(level.authors), len(level.branches)+1), false)
+		for _, err := range branchErrs {
+			g.logger.AddMessage("[!] ", "Can't make a branch: "+err.Error(), false)
+		}
+	} else {
+		if levelErr != nil {
+			g.logger.AddMessage("[!] ", "Can't play "+g.levelPath+": "+levelErr.Error(), false)
+		}
+		g.logger.AddMessage("ur_enemy$ ", "git init", false)
+	}
+	g.logger.AddMessage("ur_enemy$ ", "git config difficulty "+g.difficulty.name, false)
 	g.logger.AddMessage("ur_enemy$ ", "git commit -m 'welcome to the game'", false)
-	g.logger.AddMessage("", fmt.Sprintf("[main %s] welcome to the game", g.gridTree.commitHash[0:8]), false)
+	g.logger.AddMessage("", fmt.Sprintf("[main %s] welcome to the game", g.gridTree.headHash()[0:8]), false)
 	g.logger.AddMessage("", "1 files changed, 1 insertions(+), 0 deletions(-)", false)
 	g.logger.AddMessage("", "create mode 100644 board.bson", false)
 	g.logger.AddMessage("you$ ", "git --help", false)
@@ -97,6 +175,16 @@ func (g *Game) init() {
 	g.logger.AddMessage("[!] ", "b: new branch", false)
 	g.logger.AddMessage("[!] ", "m: merge", false)
 	g.logger.AddMessage("[!] ", "r: revert", false)
+	g.logger.AddMessage("[!] ", "a: use the selected unit's ability", false)
+	g.logger.AddMessage("[!] ", "e: end your turn", false)
+	g.logger.AddMessage("[!] ", "x: export to a real .git", false)
+	g.logger.AddMessage("[!] ", "F5: save, F9: load", false)
+	g.logger.AddMessage("[!] ", "enter: type git commands, tab completes", false)
+	g.logger.AddMessage("[!] ", "click a commit: check it out", false)
+	g.logger.AddMessage("[!] ", "arrows, mouse wheel: scroll the commit tree", false)
+	if g.resume {
+		quickLoad(g)
+	}
 	fmt.Print("Setup Git repo!\n")
 }
 
@@ -107,68 +195,139 @@ func (g *Game) Update() error {
 	if !g.inited {
 		g.init()
 	}
-	if g.botWaitPeriod == 0 {
-		// Make bot move
-		g.selected.makeBotMove(g)
-		g.botWaitPeriod = -1
-	} else {
-		g.botWaitPeriod--
-	}
+	tickTurn(g)
+	// Keys typed into the console aren't shortcuts
+	typing := g.console.focused
+	g.console.Update(g)
 
 	CPressedNow := ebiten.IsKeyPressed(ebiten.KeyC)
-	if CPressedNow && !g.CPressedLastFrame {
-		newGrid := g.gridTree.grid.Clone()
-		gitCommitGrid(g, newGrid, false, false)
+	if CPressedNow && !g.CPressedLastFrame && !typing {
+		gitCommitGrid(g, *g.selected, "", false, false)
 	}
 	g.CPressedLastFrame = CPressedNow
 	BPressedNow := ebiten.IsKeyPressed(ebiten.KeyB)
-	if BPressedNow && !g.BPressedLastFrame {
-		newGrid := g.gridTree.grid.Clone()
-		gitCommitGrid(g, newGrid, true, false)
+	if BPressedNow && !g.BPressedLastFrame && !typing {
+		gitCommitGrid(g, *g.selected, "", true, false)
 	}
 	g.BPressedLastFrame = BPressedNow
 	MPressedNow := ebiten.IsKeyPressed(ebiten.KeyM)
-	if MPressedNow && !g.MPressedLastFrame {
+	if MPressedNow && !g.MPressedLastFrame && !typing {
 		mergeCurrentBranch(g)
 	}
 	g.MPressedLastFrame = MPressedNow
 	RPressedNow := ebiten.IsKeyPressed(ebiten.KeyR)
-	if RPressedNow && !g.RPressedLastFrame {
+	if RPressedNow && !g.RPressedLastFrame && !typing {
 		nukeCurrentBranch(g)
 	}
 	g.RPressedLastFrame = RPressedNow
 	ESCPressedNow := ebiten.IsKeyPressed(ebiten.KeyEscape)
-	if ESCPressedNow && !g.ESCPressedLastFrame {
+	if ESCPressedNow && !g.ESCPressedLastFrame && !typing {
 		g.hidden = !g.hidden
 	}
 	g.ESCPressedLastFrame = ESCPressedNow
+	F5PressedNow := ebiten.IsKeyPressed(ebiten.KeyF5)
+	if F5PressedNow && !g.F5PressedLastFrame && !typing {
+		quickSave(g)
+	}
+	g.F5PressedLastFrame = F5PressedNow
+	F9PressedNow := ebiten.IsKeyPressed(ebiten.KeyF9)
+	if F9PressedNow && !g.F9PressedLastFrame && !typing {
+		quickLoad(g)
+	}
+	g.F9PressedLastFrame = F9PressedNow
+	XPressedNow := ebiten.IsKeyPressed(ebiten.KeyX)
+	if XPressedNow && !g.XPressedLastFrame && !typing {
+		gitExport(g)
+	}
+	g.XPressedLastFrame = XPressedNow
+	APressedNow := ebiten.IsKeyPressed(ebiten.KeyA)
+	if APressedNow && !g.APressedLastFrame && !typing && g.merge == nil {
+		g.selected.useAbility(g)
+	}
+	g.APressedLastFrame = APressedNow
+	EPressedNow := ebiten.IsKeyPressed(ebiten.KeyE)
+	if EPressedNow && !g.EPressedLastFrame && !typing {
+		endTurn(g)
+	}
+	g.EPressedLastFrame = EPressedNow
+	ClickedNow := ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)
+	if ClickedNow && !g.ClickedLastFrame && g.hoveredCommit != "" {
+		checkoutCommit(g, g.hoveredCommit)
+	}
+	g.ClickedLastFrame = ClickedNow
+	if g.merge != nil {
+		OPressedNow := ebiten.IsKeyPressed(ebiten.KeyO)
+		if OPressedNow && !g.OPressedLastFrame && !typing {
+			resolveConflict(g, "ours")
+		}
+		g.OPressedLastFrame = OPressedNow
+		TPressedNow := ebiten.IsKeyPressed(ebiten.KeyT)
+		if TPressedNow && !g.TPressedLastFrame && !typing {
+			resolveConflict(g, "theirs")
+		}
+		g.TPressedLastFrame = TPressedNow
+		FPressedNow := ebiten.IsKeyPressed(ebiten.KeyF)
+		if FPressedNow && !g.FPressedLastFrame && !typing {
+			resolveConflict(g, "fight")
+		}
+		g.FPressedLastFrame = FPressedNow
+	}
 
+	scrollTreeRows(g)
+	checkVictory(g)
 	return nil
 }
 
+// checkVictory ends the game once a side has been wiped off the board on
+// main. Side branches are only what-ifs.
+func checkVictory(g *Game) {
+	if g.stop || g.selected == nil || g.gridTree.head != "main" {
+		return
+	}
+	winner, over := engine.Winner(g.selected.State())
+	if !over {
+		return
+	}
+	if winner == engine.Player {
+		g.logger.AddMessage("you$ ", "git push origin main", false)
+		g.logger.AddMessage("", "You win!", false)
+	} else {
+		g.logger.AddMessage("you$ ", "sudo rm -rf / --no-preserve-root", false)
+		g.logger.AddMessage("", "whoops. it's over", false)
+	}
+	g.stop = true
+}
+
 func (g *Game) Draw(screen *ebiten.Image) {
 	screen.Fill(color.RGBA{0x33, 0x4C, 0x4C, 0xFF})
-	t2 := g.gridTree
-	for t2.prev != nil && t2.prev.grid.SizeX != 0 {
-		t2 = *t2.prev
-	}
 	if ebiten.IsKeyPressed(ebiten.KeyArrowLeft) {
-		if t2.next.grid.X < 185 {
+		if g.scrollX+treeColumnWidth < 185 {
 			g.scrollX += 1
 		}
 	} else if ebiten.IsKeyPressed(ebiten.KeyArrowRight) || g.autoScroll {
-		if g.gridTree.grid.X > 840 {
+		if g.scrollX+g.gridTree.headCommit().index*treeColumnWidth > 840 {
 			g.scrollX -= 1
 		} else {
 			g.autoScroll = false
 		}
 	}
-	if g.infoSprite.Present {
-		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%s:\n\tHP: %d/%d\n\tDefense: %d\n\tOffense: %d", g.infoSprite.Name, g.infoSprite.HP, g.infoSprite.StartingHP, g.infoSprite.Defense, g.infoSprite.Offense), screenWidth-110, 0)
+	if g.merge != nil && g.merge.isUnresolved(g.hovered) {
+		c := g.merge.conflictAt(g.hovered)
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("CONFLICT %s\n<<<<<<< ours\n%s\n=======\n%s\n>>>>>>> theirs", cellName(c.pos), describeTile(c.ours), describeTile(c.theirs)), screenWidth-160, 0)
+	} else if g.infoSprite.Present {
+		info := fmt.Sprintf("%s:\n\tHP: %d/%d\n\tDefense: %d\n\tOffense: %d\n\tAP: %d/%d%s", g.infoSprite.Name, g.infoSprite.HP, g.infoSprite.StartingHP, g.infoSprite.Defense, g.infoSprite.Offense, g.infoSprite.AP, unitActionPoints, abilityText(g.infoSprite))
+		if matchup := matchupText(g); matchup != "" {
+			// The prediction is part of the panel, it needs the width
+			ebitenutil.DebugPrintAt(screen, info+"\n\n"+matchup, screenWidth-230, 0)
+		} else {
+			ebitenutil.DebugPrintAt(screen, info, screenWidth-110, 0)
+		}
 	}
-	drawGridTree(g, &t2, screen, 50, g.scrollX)
+	drawGridTree(g, &g.gridTree, screen, treeTop-g.scrollY, g.scrollX)
 	ebitenutil.DebugPrint(screen, fmt.Sprintf("FPS: %f", ebiten.ActualFPS()))
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Turn %d: %s", g.turn.number, g.turn.phase), 0, 16)
 	g.logger.Draw(screen)
+	g.console.Draw(screen, g.logger)
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
@@ -176,10 +335,34 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
 }
 
 func main() {
+	savePath := flag.String("save", "molniya.save", "file F5 saves the game to and F9 loads it from")
+	resume := flag.Bool("resume", false, "pick up the game in the save file on startup")
+	exportPath := flag.String("export", "molniya.git", "bare git repository x exports the game history to")
+	levelPath := flag.String("level", "", "git repository to build the level from instead of a random board")
+	thinkTime := flag.Duration("think", 500*time.Millisecond, "how long the bot gets to think about each move")
+	unitsPath := flag.String("units", "", "unit definitions to play with instead of the built-in units.json")
+	difficultyName := flag.String("difficulty", "normal", "how hard the bot tries: easy, normal or hard")
+	maxBranches := flag.Int("branches", 5, "most branches, main included, a game can have at once, 0 for no limit")
+	personality := flag.String("personality", "", "make every faction play balanced, aggressive or cautious instead of its own way")
+	flag.Parse()
+
+	if err := loadUnitDefs(*unitsPath); err != nil {
+		log.Fatal(err)
+	}
+	if *personality != "" {
+		if err := forcePersonality(*personality); err != nil {
+			log.Fatal(err)
+		}
+	}
+	diff, err := findDifficulty(*difficultyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ebiten.SetWindowSize(screenWidth*2, screenHeight*2)
 	ebiten.SetWindowTitle("Sprites (Ebitengine Demo)")
 	ebiten.SetWindowResizable(true)
-	if err := ebiten.RunGame(&Game{}); err != nil {
+	if err := ebiten.RunGame(&Game{savePath: *savePath, resume: *resume, exportPath: *exportPath, levelPath: *levelPath, thinkTime: *thinkTime, difficulty: diff, rules: gameRules{maxBranches: *maxBranches}}); err != nil {
 		log.Fatal(err)
 	}
 }
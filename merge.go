@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// tileConflict is a tile both sides of a merge changed, in different ways.
+type tileConflict struct {
+	pos    vec2i
+	ours   Tile
+	theirs Tile
+}
+
+func tilesEqual(a, b *Tile) bool {
+	return a.Occupant == b.Occupant && a.Color == b.Color
+}
+
+// cellName gives a tile a chess-like name, a column letter and a row number.
+func cellName(pos vec2i) string {
+	return fmt.Sprintf("%c%d", 'a'+pos.y, pos.x+1)
+}
+
+// mergeGrids does a three-way merge of two boards against the board they
+// both came from. A tile changed on only one side takes that side's
+// version, a tile changed on both sides in different ways is a conflict
+// and keeps our version until it's resolved.
+func mergeGrids(base, ours, theirs TileGrid) (TileGrid, []tileConflict) {
+	merged := ours.Clone()
+	var conflicts []tileConflict
+	for j := 0; j < len(merged.Tiles); j++ {
+		for i := 0; i < len(merged.Tiles[j]); i++ {
+			b, o, t := base.Tiles[j][i], ours.Tiles[j][i], theirs.Tiles[j][i]
+			switch {
+			case tilesEqual(o, t), tilesEqual(b, t):
+				// Nothing new on their side
+			case tilesEqual(b, o):
+				*merged.Tiles[j][i] = *t
+			default:
+				conflicts = append(conflicts, tileConflict{
+					pos:    vec2i{x: j, y: i, valid: true},
+					ours:   *o,
+					theirs: *t,
+				})
+			}
+		}
+	}
+	return merged, conflicts
+}
+
+// changedTiles counts the tiles that differ between two boards.
+func changedTiles(a, b TileGrid) int {
+	n := 0
+	for j := 0; j < len(a.Tiles); j++ {
+		for i := 0; i < len(a.Tiles[j]); i++ {
+			if !tilesEqual(a.Tiles[j][i], b.Tiles[j][i]) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// moveSummary says what happened between two boards the way a player would:
+// who moved where, who got captured and who was hurt or healed in place.
+func moveSummary(before, after TileGrid) string {
+	type placed struct {
+		pos  vec2i
+		unit Unit
+	}
+	var gone, arrived []placed
+	var hurt []string
+	for j := 0; j < len(before.Tiles) && j < len(after.Tiles); j++ {
+		for i := 0; i < len(before.Tiles[j]) && i < len(after.Tiles[j]); i++ {
+			b, a := before.Tiles[j][i].Occupant, after.Tiles[j][i].Occupant
+			pos := vec2i{x: j, y: i, valid: true}
+			if b.Present && a.Present && b.Name == a.Name && b.BotUnit == a.BotUnit {
+				if a.HP != b.HP {
+					hurt = append(hurt, fmt.Sprintf("%s %+d HP", a.Name, a.HP-b.HP))
+				}
+				continue
+			}
+			if b.Present {
+				gone = append(gone, placed{pos, b})
+			}
+			if a.Present {
+				arrived = append(arrived, placed{pos, a})
+			}
+		}
+	}
+	var moves, captures []string
+	for _, from := range gone {
+		k := slices.IndexFunc(arrived, func(to placed) bool {
+			return to.unit.Name == from.unit.Name && to.unit.BotUnit == from.unit.BotUnit
+		})
+		if k < 0 {
+			captures = append(captures, "captured "+from.unit.Name)
+			continue
+		}
+		to := arrived[k]
+		arrived = slices.Delete(arrived, k, k+1)
+		move := fmt.Sprintf("%s %s->%s", to.unit.Name, cellName(from.pos), cellName(to.pos))
+		if to.unit.HP != from.unit.HP {
+			move += fmt.Sprintf(" (%+d HP)", to.unit.HP-from.unit.HP)
+		}
+		moves = append(moves, move)
+	}
+	return strings.Join(slices.Concat(moves, captures, hurt), ", ")
+}
+
+// mergeState is a merge that stopped on conflicts and is waiting for the
+// player to settle every conflicting tile on the board.
+type mergeState struct {
+	branch string
+	// Set when the branch gets deleted once it's merged
+	drop      bool
+	ours      string
+	theirs    string
+	conflicts []tileConflict
+	resolved  map[vec2i]bool
+}
+
+func (m *mergeState) conflictAt(pos vec2i) *tileConflict {
+	for k := range m.conflicts {
+		c := &m.conflicts[k]
+		if c.pos.x == pos.x && c.pos.y == pos.y {
+			return c
+		}
+	}
+	return nil
+}
+
+func (m *mergeState) isUnresolved(pos vec2i) bool {
+	pos.valid = true
+	return m.conflictAt(pos) != nil && !m.resolved[pos]
+}
+
+func (m *mergeState) unresolved() []tileConflict {
+	var out []tileConflict
+	for _, c := range m.conflicts {
+		if !m.resolved[c.pos] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func describeTile(t Tile) string {
+	if !t.Occupant.Present {
+		return "empty"
+	}
+	return t.Occupant.Name
+}
+
+func logUnresolved(g *Game) {
+	unresolved := g.merge.unresolved()
+	g.logger.AddMessage("", "Unmerged tiles:", true)
+	for _, c := range unresolved {
+		g.logger.AddMessage("", fmt.Sprintf("  both modified: %s (ours: %s, theirs: %s)", cellName(c.pos), describeTile(c.ours), describeTile(c.theirs)), true)
+	}
+}
+
+// startConflictedMerge leaves the merged board on the table with every
+// conflicting tile still holding our side, and waits for the player.
+func startConflictedMerge(g *Game, branch, ours, theirs string, merged TileGrid, conflicts []tileConflict, drop bool) {
+	g.merge = &mergeState{
+		branch:    branch,
+		drop:      drop,
+		ours:      ours,
+		theirs:    theirs,
+		conflicts: conflicts,
+		resolved:  make(map[vec2i]bool),
+	}
+	setWorkingGrid(g, merged)
+	g.logger.AddMessage("", "CONFLICT (content): Merge conflict in board.bson", true)
+	g.logger.AddMessage("", "Automatic merge failed; fix conflicts and then commit the result.", true)
+	logUnresolved(g)
+	g.logger.AddMessage("[!] ", "click a conflict, then o: ours, t: theirs, f: fight", false)
+}
+
+// resolveConflict settles the conflict under the player's selection. side is
+// "ours", "theirs" or "fight".
+func resolveConflict(g *Game, side string) {
+	pos := g.selected.selectedCells[0]
+	if !pos.valid || !g.merge.isUnresolved(pos) {
+		g.logger.AddMessage("[!] ", "Select a conflicting tile first", false)
+		return
+	}
+	c := g.merge.conflictAt(pos)
+	tile := g.selected.Tiles[pos.x][pos.y]
+	switch side {
+	case "ours":
+		*tile = c.ours
+	case "theirs":
+		*tile = c.theirs
+	case "fight":
+		ours, theirs := c.ours.Occupant, c.theirs.Occupant
+		if !ours.Present || !theirs.Present {
+			g.logger.AddMessage("[!] ", fmt.Sprintf("Nobody to fight on %s, pick ours or theirs", cellName(pos)), false)
+			return
+		}
+		if ours.BotUnit == theirs.BotUnit {
+			g.logger.AddMessage("[!] ", fmt.Sprintf("Both sides of %s are on the same team, pick ours or theirs", cellName(pos)), false)
+			return
+		}
+		// Only one timeline's unit gets to keep the tile, so fight it out
+		for ours.HP > 0 && theirs.HP > 0 {
+			logCombat(g, ours.AttackEnemy(&theirs, g.rng.IntN(100)))
+		}
+		if theirs.HP > 0 {
+			*tile = c.theirs
+			tile.Occupant = theirs
+			side = "theirs"
+		} else {
+			*tile = c.ours
+			tile.Occupant = ours
+			if ours.HP <= 0 {
+				tile.Occupant = Unit{}
+			}
+			side = "ours"
+		}
+	}
+	g.merge.resolved[c.pos] = true
+	g.selected.clearSelection()
+	g.logger.AddMessage("you$ ", fmt.Sprintf("git checkout --%s %s", side, cellName(pos)), false)
+
+	if len(g.merge.unresolved()) > 0 {
+		logUnresolved(g)
+		return
+	}
+	m := g.merge
+	g.logger.AddMessage("you$ ", "git add board.bson", false)
+	g.logger.AddMessage("you$ ", "git commit --no-edit", false)
+	node, err := g.gridTree.commit(*g.selected, []string{m.ours, m.theirs}, authorPlayer, fmt.Sprintf("Merge branch '%s'", m.branch))
+	if err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	g.merge = nil
+	g.logger.AddMessage("", fmt.Sprintf("[%s %s] Merge branch '%s'", g.gridTree.branchLabel(), node.hash[0:8], m.branch), true)
+	finishMerge(g, m.branch, m.theirs, m.drop)
+}
+
+// abortMerge throws the half-merged board away. When the m key started the
+// merge it also goes back to the branch that was being merged.
+func abortMerge(g *Game) {
+	m := g.merge
+	g.merge = nil
+	if m.drop {
+		g.logger.AddMessage("you$ ", "git checkout "+m.branch, false)
+		g.gridTree.checkout(m.branch)
+	}
+	setWorkingGrid(g, g.gridTree.headCommit().grid)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Authors that can show up on a commit
+const (
+	authorPlayer = "you"
+	authorEnemy  = "ur_enemy"
+)
+
+// The file name every commit tree stores the board under
+const boardFileName = "board.bson"
+
+// ObjectStore is a content-addressed store laid out the same way git lays out
+// its object database: every object is "<kind> <len>\x00<body>" and is keyed
+// by the SHA-1 of that. Two identical boards always end up as the same blob.
+type ObjectStore struct {
+	objects map[string][]byte
+}
+
+// Commit is the decoded form of a commit object.
+type Commit struct {
+	Tree    string
+	Parents []string
+	Author  string
+	Message string
+	// What happened on the board, kept as the message body
+	Summary string
+}
+
+func NewObjectStore() *ObjectStore {
+	return &ObjectStore{
+		objects: make(map[string][]byte),
+	}
+}
+
+func hashObject(kind string, body []byte) (string, []byte) {
+	raw := append([]byte(fmt.Sprintf("%s %d\x00", kind, len(body))), body...)
+	sum := sha1.Sum(raw)
+	return hex.EncodeToString(sum[:]), raw
+}
+
+// Put stores an object and returns its hash. Storing the same content twice
+// is a no-op.
+func (s *ObjectStore) Put(kind string, body []byte) string {
+	hash, raw := hashObject(kind, body)
+	if _, ok := s.objects[hash]; !ok {
+		s.objects[hash] = raw
+	}
+	return hash
+}
+
+// Get returns the kind and body of an object.
+func (s *ObjectStore) Get(hash string) (string, []byte, error) {
+	raw, ok := s.objects[hash]
+	if !ok {
+		return "", nil, fmt.Errorf("object %s not found", hash)
+	}
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return "", nil, fmt.Errorf("object %s is corrupt", hash)
+	}
+	kind, _, _ := strings.Cut(string(raw[:nul]), " ")
+	return kind, raw[nul+1:], nil
+}
+
+// PutRaw stores an object already in "<kind> <len>\x00<body>" form, such as
+// one read back from a save file.
+func (s *ObjectStore) PutRaw(raw []byte) (string, error) {
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return "", fmt.Errorf("object has no header")
+	}
+	kind, size, _ := strings.Cut(string(raw[:nul]), " ")
+	if size != fmt.Sprint(len(raw)-nul-1) {
+		return "", fmt.Errorf("%s object has the wrong length", kind)
+	}
+	return s.Put(kind, raw[nul+1:]), nil
+}
+
+// Raw is the object exactly as it's hashed.
+func (s *ObjectStore) Raw(hash string) []byte {
+	return s.objects[hash]
+}
+
+// Hashes lists every object in the store in a stable order.
+func (s *ObjectStore) Hashes() []string {
+	return sortedKeys(s.objects)
+}
+
+// Len is the number of distinct objects in the store.
+func (s *ObjectStore) Len() int {
+	return len(s.objects)
+}
+
+// WriteBoard stores the board as board.bson wrapped in a single-file tree,
+// and returns the tree hash.
+func (s *ObjectStore) WriteBoard(grid *TileGrid) (string, error) {
+	data, err := encodeBoard(grid)
+	if err != nil {
+		return "", err
+	}
+	blob := s.Put("blob", data)
+	raw, _ := hex.DecodeString(blob)
+	tree := append([]byte("100644 "+boardFileName+"\x00"), raw...)
+	return s.Put("tree", tree), nil
+}
+
+// ReadBoard decodes the board.bson held in a tree.
+func (s *ObjectStore) ReadBoard(tree string) (TileGrid, error) {
+	kind, body, err := s.Get(tree)
+	if err != nil {
+		return TileGrid{}, err
+	}
+	if kind != "tree" {
+		return TileGrid{}, fmt.Errorf("object %s is a %s, not a tree", tree, kind)
+	}
+	name := []byte(boardFileName + "\x00")
+	at := bytes.Index(body, name)
+	if at < 0 || len(body) < at+len(name)+20 {
+		return TileGrid{}, fmt.Errorf("tree %s has no %s", tree, boardFileName)
+	}
+	blob := hex.EncodeToString(body[at+len(name) : at+len(name)+20])
+	kind, data, err := s.Get(blob)
+	if err != nil {
+		return TileGrid{}, err
+	}
+	if kind != "blob" {
+		return TileGrid{}, fmt.Errorf("object %s is a %s, not a blob", blob, kind)
+	}
+	return decodeBoard(data)
+}
+
+// WriteCommit stores a commit and returns its hash.
+func (s *ObjectStore) WriteCommit(c Commit) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "tree %s\n", c.Tree)
+	for _, p := range c.Parents {
+		fmt.Fprintf(&body, "parent %s\n", p)
+	}
+	// Timestamps are pinned so that the same position reached the same way
+	// always hashes the same. When a commit was made is kept next to the
+	// tree instead.
+	fmt.Fprintf(&body, "author %s <%s@molniya> 0 +0000\n", c.Author, c.Author)
+	fmt.Fprintf(&body, "committer %s <%s@molniya> 0 +0000\n", c.Author, c.Author)
+	fmt.Fprintf(&body, "\n%s\n", c.Message)
+	if c.Summary != "" {
+		fmt.Fprintf(&body, "\n%s\n", c.Summary)
+	}
+	return s.Put("commit", []byte(body.String()))
+}
+
+// ReadCommit decodes a commit object.
+func (s *ObjectStore) ReadCommit(hash string) (Commit, error) {
+	kind, body, err := s.Get(hash)
+	if err != nil {
+		return Commit{}, err
+	}
+	if kind != "commit" {
+		return Commit{}, fmt.Errorf("object %s is a %s, not a commit", hash, kind)
+	}
+	header, msg, _ := strings.Cut(string(body), "\n\n")
+	var c Commit
+	c.Message, c.Summary, _ = strings.Cut(strings.TrimSuffix(msg, "\n"), "\n\n")
+	for _, line := range strings.Split(header, "\n") {
+		key, val, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			c.Tree = val
+		case "parent":
+			c.Parents = append(c.Parents, val)
+		case "author":
+			c.Author, _, _ = strings.Cut(val, " <")
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+// saveFormatVersion is bumped whenever the save file changes shape.
+const saveFormatVersion = 1
+
+// A save file is a single BSON document holding everything needed to pick a
+// game back up: the whole object store, the layout of the tree view, every
+// ref, HEAD, the board on the table, the log and the RNG.
+
+func encodeStringMap(m map[string]string) bsonDoc {
+	doc := bsonDoc{}
+	for _, k := range sortedKeys(m) {
+		doc = append(doc, bsonElem{k, m[k]})
+	}
+	return doc
+}
+
+func decodeStringMap(d bsonDoc) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, e := range d {
+		s, ok := e.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%q is a %T, not a string", e.Key, e.Value)
+		}
+		m[e.Key] = s
+	}
+	return m, nil
+}
+
+func saveGame(g *Game, path string) error {
+	tree := &g.gridTree
+	objects := []any{}
+	for _, h := range tree.objects.Hashes() {
+		objects = append(objects, tree.objects.Raw(h))
+	}
+	commits := []any{}
+	for _, c := range tree.order {
+		commits = append(commits, bsonDoc{{"hash", c.hash}, {"lane", int32(c.lane)}, {"time", c.time.Unix()}})
+	}
+	lanes := bsonDoc{}
+	for _, k := range sortedKeys(tree.lanes) {
+		lanes = append(lanes, bsonElem{k, int32(tree.lanes[k])})
+	}
+	working, err := encodeBoard(g.selected)
+	if err != nil {
+		return err
+	}
+	messages := []any{}
+	for _, m := range g.logger.messages {
+		messages = append(messages, bsonDoc{{"prompt", m.Prompt}, {"text", m.Text}, {"time", m.Timestamp.UnixNano()}})
+	}
+	rng, err := g.pcg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	botWait := g.botWaitPeriod
+	if g.botPlan != nil {
+		// The bot was mid-thought, it'll think again after loading
+		botWait = 0
+	}
+
+	doc := bsonDoc{
+		{"version", int32(saveFormatVersion)},
+		{"objects", objects},
+		{"commits", commits},
+		{"refs", encodeStringMap(tree.refs)},
+		{"upstream", encodeStringMap(tree.upstream)},
+		{"lanes", lanes},
+		{"head", tree.head},
+		{"detached", tree.detached},
+		{"working", working},
+		{"log", messages},
+		{"rng", rng},
+		{"stop", g.stop},
+		{"bot_wait", int32(botWait)},
+		{"bot_gambles", int32(g.botGambles)},
+		{"turn", int32(g.turn.number)},
+		{"phase", int32(g.turn.phase)},
+	}
+	if g.merge != nil {
+		resolved := []any{}
+		for _, c := range g.merge.conflicts {
+			if g.merge.resolved[c.pos] {
+				resolved = append(resolved, cellName(c.pos))
+			}
+		}
+		doc = append(doc, bsonElem{"merge", bsonDoc{
+			{"branch", g.merge.branch},
+			{"drop", g.merge.drop},
+			{"ours", g.merge.ours},
+			{"theirs", g.merge.theirs},
+			{"resolved", resolved},
+		}})
+	}
+	data, err := doc.Marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadGame(g *Game, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc, err := unmarshalBSON(data)
+	if err != nil {
+		return err
+	}
+	if v, err := doc.Int("version"); err != nil || v > saveFormatVersion {
+		return fmt.Errorf("%s: unsupported save version", path)
+	}
+
+	tree := NewGridTree()
+	objects, err := doc.Array("objects")
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		raw, ok := o.([]byte)
+		if !ok {
+			return fmt.Errorf("object is a %T", o)
+		}
+		if _, err := tree.objects.PutRaw(raw); err != nil {
+			return err
+		}
+	}
+	commits, err := doc.Array("commits")
+	if err != nil {
+		return err
+	}
+	for _, v := range commits {
+		c, ok := v.(bsonDoc)
+		if !ok {
+			return fmt.Errorf("commit is a %T", v)
+		}
+		hash, err := c.String("hash")
+		if err != nil {
+			return err
+		}
+		lane, err := c.Int("lane")
+		if err != nil {
+			return err
+		}
+		if err := tree.addCommit(hash, lane); err != nil {
+			return err
+		}
+		if c.Has("time") {
+			ts, err := c.Int64("time")
+			if err != nil {
+				return err
+			}
+			tree.commits[hash].time = time.Unix(ts, 0)
+		}
+	}
+	for key, dst := range map[string]*map[string]string{"refs": &tree.refs, "upstream": &tree.upstream} {
+		d, err := doc.Doc(key)
+		if err != nil {
+			return err
+		}
+		if *dst, err = decodeStringMap(d); err != nil {
+			return err
+		}
+	}
+	lanes, err := doc.Doc("lanes")
+	if err != nil {
+		return err
+	}
+	tree.lanes = make(map[string]int)
+	for _, e := range lanes {
+		if tree.lanes[e.Key], err = lanes.Int(e.Key); err != nil {
+			return err
+		}
+	}
+	if tree.head, err = doc.String("head"); err != nil {
+		return err
+	}
+	if tree.detached, err = doc.String("detached"); err != nil {
+		return err
+	}
+	if err := checkRefs(&tree); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	workingData, err := doc.Binary("working")
+	if err != nil {
+		return err
+	}
+	working, err := decodeBoard(workingData)
+	if err != nil {
+		return err
+	}
+	rng, err := doc.Binary("rng")
+	if err != nil {
+		return err
+	}
+	pcg := &rand.PCG{}
+	if err := pcg.UnmarshalBinary(rng); err != nil {
+		return err
+	}
+	entries, err := doc.Array("log")
+	if err != nil {
+		return err
+	}
+	var messages []LogMessage
+	for _, v := range entries {
+		m, ok := v.(bsonDoc)
+		if !ok {
+			return fmt.Errorf("log entry is a %T", v)
+		}
+		msg := LogMessage{Done: true}
+		if msg.Prompt, err = m.String("prompt"); err != nil {
+			return err
+		}
+		if msg.Text, err = m.String("text"); err != nil {
+			return err
+		}
+		ts, err := m.Int64("time")
+		if err != nil {
+			return err
+		}
+		msg.Timestamp = time.Unix(0, ts)
+		messages = append(messages, msg)
+	}
+	stop, err := doc.Bool("stop")
+	if err != nil {
+		return err
+	}
+	botWait, err := doc.Int("bot_wait")
+	if err != nil {
+		return err
+	}
+	botGambles := g.difficulty.gambles
+	if doc.Has("bot_gambles") {
+		if botGambles, err = doc.Int("bot_gambles"); err != nil {
+			return err
+		}
+	}
+	turn := turnState{number: 1}
+	if doc.Has("turn") {
+		var ph int
+		if turn.number, err = doc.Int("turn"); err != nil {
+			return err
+		}
+		if ph, err = doc.Int("phase"); err != nil {
+			return err
+		}
+		turn.phase = phase(ph)
+	}
+
+	var merge *mergeState
+	if doc.Has("merge") {
+		if merge, err = decodeMergeState(&tree, doc); err != nil {
+			return err
+		}
+	}
+
+	// Everything decoded, only now touch the running game
+	g.gridTree = tree
+	g.pcg = pcg
+	g.rng = rand.New(pcg)
+	g.logger.messages = messages
+	g.stop = stop
+	g.botWaitPeriod = botWait
+	g.botPlan = nil
+	g.botGambles = botGambles
+	g.turn = turn
+	g.merge = merge
+	// The board on the table is the one that was saved, not HEAD's
+	setWorkingGrid(g, working)
+	g.autoScroll = true
+	return nil
+}
+
+// checkRefs makes sure every branch and HEAD lead to a commit the save
+// brought along, so a damaged save can't leave HEAD pointing at nothing.
+func checkRefs(tree *GridTree) error {
+	for _, name := range sortedKeys(tree.refs) {
+		if tree.commits[tree.refs[name]] == nil {
+			return fmt.Errorf("branch %s points at missing commit %q", name, tree.refs[name])
+		}
+	}
+	switch _, ok := tree.refs[tree.head]; {
+	case tree.head == "" && tree.commits[tree.detached] == nil:
+		return fmt.Errorf("HEAD is detached at missing commit %q", tree.detached)
+	case tree.head != "" && !ok:
+		return fmt.Errorf("HEAD points at missing branch %q", tree.head)
+	}
+	return nil
+}
+
+func decodeMergeState(tree *GridTree, doc bsonDoc) (*mergeState, error) {
+	d, _ := doc.Doc("merge")
+	m := &mergeState{resolved: make(map[vec2i]bool)}
+	var err error
+	if m.branch, err = d.String("branch"); err != nil {
+		return nil, err
+	}
+	// Merges saved before the console could start them all came from the m key
+	m.drop = true
+	if d.Has("drop") {
+		if m.drop, err = d.Bool("drop"); err != nil {
+			return nil, err
+		}
+	}
+	if m.ours, err = d.String("ours"); err != nil {
+		return nil, err
+	}
+	if m.theirs, err = d.String("theirs"); err != nil {
+		return nil, err
+	}
+	ours, theirs := tree.commits[m.ours], tree.commits[m.theirs]
+	if ours == nil || theirs == nil {
+		return nil, fmt.Errorf("merge refers to missing commits")
+	}
+	base := tree.commits[tree.mergeBase(m.ours, m.theirs)]
+	_, m.conflicts = mergeGrids(base.grid, ours.grid, theirs.grid)
+	resolved, err := d.Array("resolved")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resolved {
+		for _, c := range m.conflicts {
+			if cellName(c.pos) == r {
+				m.resolved[c.pos] = true
+			}
+		}
+	}
+	return m, nil
+}
+
+func quickSave(g *Game) {
+	g.logger.AddMessage("you$ ", "git bundle create "+g.savePath+" --all", false)
+	if err := saveGame(g, g.savePath); err != nil {
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	g.logger.AddMessage("", fmt.Sprintf("Saved %d objects, %d commits", g.gridTree.objects.Len(), len(g.gridTree.order)), true)
+}
+
+func quickLoad(g *Game) {
+	if err := loadGame(g, g.savePath); err != nil {
+		g.logger.AddMessage("you$ ", "git clone "+g.savePath, false)
+		g.logger.AddMessage("", "fatal: "+err.Error(), true)
+		return
+	}
+	g.logger.AddMessage("you$ ", "git clone "+g.savePath, false)
+	g.logger.AddMessage("", fmt.Sprintf("Receiving objects: 100%% (%d/%d), done.", g.gridTree.objects.Len(), g.gridTree.objects.Len()), true)
+}
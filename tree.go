@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GridTree is the game's repository: every commit ever made, the branches
+// pointing into them and HEAD. Commits can have any number of parents and
+// children, so forks and merges are represented as they are in git.
+type GridTree struct {
+	objects *ObjectStore
+	commits map[string]*commitNode
+	// Creation order, which is also the column a commit is drawn in
+	order []*commitNode
+
+	refs map[string]string
+	// Name of the checked out branch, empty while HEAD is detached
+	head     string
+	detached string
+
+	// Row of the tree view each branch draws its commits on
+	lanes map[string]int
+	// Branch each branch was created from, merges go back into it
+	upstream map[string]string
+}
+
+type commitNode struct {
+	hash     string
+	parents  []string
+	children []string
+	grid     TileGrid
+
+	author string
+	// When the commit was first made. It isn't part of the hash, saves keep
+	// it alongside.
+	time    time.Time
+	message string
+	summary string
+
+	lane  int
+	index int
+}
+
+// date is when the commit was made the way git log prints it. Commits from
+// older saves and from levels don't know.
+func (c *commitNode) date() string {
+	if c.time.IsZero() {
+		return "unknown"
+	}
+	return c.time.Format("Mon Jan 2 15:04:05 2006")
+}
+
+func NewGridTree() GridTree {
+	return GridTree{
+		objects:  NewObjectStore(),
+		commits:  make(map[string]*commitNode),
+		refs:     make(map[string]string),
+		head:     "main",
+		lanes:    map[string]int{"main": 0},
+		upstream: make(map[string]string),
+	}
+}
+
+// headHash is the commit HEAD points at, empty on an unborn branch.
+func (t *GridTree) headHash() string {
+	if t.head == "" {
+		return t.detached
+	}
+	return t.refs[t.head]
+}
+
+func (t *GridTree) headCommit() *commitNode {
+	return t.commits[t.headHash()]
+}
+
+// branchLabel is what git prints in "[main 1234abcd]" style lines.
+func (t *GridTree) branchLabel() string {
+	if t.head == "" {
+		return "detached HEAD"
+	}
+	return t.head
+}
+
+// commit records grid as a new commit with the given parents and moves HEAD
+// (and the checked out branch) to it. Commits with one parent get a summary
+// of the moves since it.
+func (t *GridTree) commit(grid TileGrid, parents []string, author string, message string) (*commitNode, error) {
+	board, err := t.objects.WriteBoard(&grid)
+	if err != nil {
+		return nil, err
+	}
+	c := Commit{
+		Tree:    board,
+		Parents: parents,
+		Author:  author,
+		Message: message,
+	}
+	if len(parents) == 1 {
+		if summary := moveSummary(t.commits[parents[0]].grid, grid); summary != message {
+			c.Summary = summary
+		}
+	}
+	hash := t.objects.WriteCommit(c)
+	node, ok := t.commits[hash]
+	if !ok {
+		node = &commitNode{
+			hash:    hash,
+			parents: parents,
+			grid:    grid.Clone(),
+			author:  author,
+			time:    time.Now(),
+			message: message,
+			summary: c.Summary,
+			lane:    t.headLane(),
+			index:   len(t.order),
+		}
+		node.grid.IsSelectedGrid = false
+		node.grid.clearSelection()
+		for _, p := range parents {
+			parent := t.commits[p]
+			parent.children = append(parent.children, hash)
+		}
+		t.commits[hash] = node
+		t.order = append(t.order, node)
+	}
+	t.moveHead(hash)
+	return node, nil
+}
+
+// addCommit brings a commit that's already in the object store into the
+// tree, drawn on the given lane. Its parents have to be added first.
+func (t *GridTree) addCommit(hash string, lane int) error {
+	c, err := t.objects.ReadCommit(hash)
+	if err != nil {
+		return err
+	}
+	grid, err := t.objects.ReadBoard(c.Tree)
+	if err != nil {
+		return err
+	}
+	node := &commitNode{
+		hash:    hash,
+		parents: c.Parents,
+		grid:    grid,
+		author:  c.Author,
+		message: c.Message,
+		summary: c.Summary,
+		lane:    lane,
+		index:   len(t.order),
+	}
+	for _, p := range c.Parents {
+		parent, ok := t.commits[p]
+		if !ok {
+			return fmt.Errorf("commit %s is missing its parent %s", hash, p)
+		}
+		parent.children = append(parent.children, hash)
+	}
+	t.commits[hash] = node
+	t.order = append(t.order, node)
+	return nil
+}
+
+func (t *GridTree) moveHead(hash string) {
+	if t.head == "" {
+		t.detached = hash
+	} else {
+		t.refs[t.head] = hash
+	}
+}
+
+func (t *GridTree) headLane() int {
+	if lane, ok := t.lanes[t.head]; ok {
+		return lane
+	}
+	if c := t.headCommit(); c != nil {
+		return c.lane
+	}
+	return 0
+}
+
+// createBranch points a new branch at the HEAD commit, without checking it
+// out.
+func (t *GridTree) createBranch(name string) error {
+	if _, ok := t.refs[name]; ok {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	if err := t.refClash(name, ""); err != nil {
+		return err
+	}
+	if t.headHash() == "" {
+		return fmt.Errorf("not a valid object name: 'HEAD'")
+	}
+	t.refs[name] = t.headHash()
+	t.upstream[name] = t.head
+	if t.head == "" {
+		// Off a detached HEAD there's no branch to go back to, main is the
+		// one that counts
+		t.upstream[name] = "main"
+	}
+	t.lanes[name] = t.freeLane()
+	return nil
+}
+
+// refClash refuses a branch name that would need an existing branch, other
+// than except, to be a directory under refs/heads or the other way round.
+// git can't store both a and a/b.
+func (t *GridTree) refClash(name, except string) error {
+	for _, other := range sortedKeys(t.refs) {
+		if other == except {
+			continue
+		}
+		if strings.HasPrefix(other, name+"/") || strings.HasPrefix(name, other+"/") {
+			return fmt.Errorf("cannot lock ref 'refs/heads/%s': 'refs/heads/%s' exists", name, other)
+		}
+	}
+	return nil
+}
+
+func (t *GridTree) deleteBranch(name string) error {
+	if _, ok := t.refs[name]; !ok {
+		return fmt.Errorf("branch '%s' not found", name)
+	}
+	if name == t.head {
+		return fmt.Errorf("cannot delete branch '%s' checked out", name)
+	}
+	for b, up := range t.upstream {
+		if up == name {
+			t.upstream[b] = t.upstream[name]
+		}
+	}
+	delete(t.refs, name)
+	delete(t.lanes, name)
+	delete(t.upstream, name)
+	return nil
+}
+
+// renameBranch gives a branch a new name, keeping its row in the tree view
+// and the branch it merges back into.
+func (t *GridTree) renameBranch(old, name string) error {
+	if _, ok := t.refs[old]; !ok {
+		return fmt.Errorf("no branch named '%s'", old)
+	}
+	if _, ok := t.refs[name]; ok {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	if err := t.refClash(name, old); err != nil {
+		return err
+	}
+	t.refs[name], t.lanes[name] = t.refs[old], t.lanes[old]
+	delete(t.refs, old)
+	delete(t.lanes, old)
+	if up, ok := t.upstream[old]; ok {
+		t.upstream[name] = up
+		delete(t.upstream, old)
+	}
+	for b, up := range t.upstream {
+		if up == old {
+			t.upstream[b] = name
+		}
+	}
+	if t.head == old {
+		t.head = name
+	}
+	return nil
+}
+
+// freeLane is the lowest tree view row no live branch is drawing on.
+func (t *GridTree) freeLane() int {
+	used := make(map[int]bool)
+	for _, lane := range t.lanes {
+		used[lane] = true
+	}
+	lane := 0
+	for used[lane] {
+		lane++
+	}
+	return lane
+}
+
+// checkoutDetached points HEAD straight at a commit, off every branch.
+func (t *GridTree) checkoutDetached(hash string) {
+	t.head = ""
+	t.detached = hash
+}
+
+// resolve turns a revision, spelled the way git spells them, into a commit:
+// HEAD, a branch, or a hash or a unique prefix of one, followed by any
+// number of ~N to walk back N first parents and ^N to take the Nth parent.
+func (t *GridTree) resolve(rev string) (string, error) {
+	bad := fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree.", rev)
+	base, walk := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		base, walk = rev[:i], rev[i:]
+	}
+	var hash string
+	switch hit, ok := t.refs[base]; {
+	case base == "HEAD" || base == "@":
+		hash = t.headHash()
+	case ok:
+		hash = hit
+	case len(base) >= 4:
+		for h := range t.commits {
+			if strings.HasPrefix(h, base) {
+				if hash != "" {
+					return "", fmt.Errorf("short object ID %s is ambiguous", base)
+				}
+				hash = h
+			}
+		}
+	}
+	if hash == "" {
+		return "", bad
+	}
+	for walk != "" {
+		op := walk[0]
+		walk = walk[1:]
+		if op != '~' && op != '^' {
+			return "", bad
+		}
+		n := 1
+		if digits := len(walk) - len(strings.TrimLeft(walk, "0123456789")); digits > 0 {
+			var err error
+			if n, err = strconv.Atoi(walk[:digits]); err != nil {
+				return "", bad
+			}
+			walk = walk[digits:]
+		}
+		parents := t.commits[hash].parents
+		switch {
+		case op == '^' && n == 0:
+			// The commit itself
+		case op == '^':
+			// The nth parent, a merge's second parent is ^2
+			if n > len(parents) {
+				return "", bad
+			}
+			hash = parents[n-1]
+		default:
+			// n generations back along first parents
+			for ; n > 0; n-- {
+				if len(parents) == 0 {
+					return "", bad
+				}
+				hash = parents[0]
+				parents = t.commits[hash].parents
+			}
+		}
+	}
+	return hash, nil
+}
+
+func (t *GridTree) checkout(name string) error {
+	if _, ok := t.refs[name]; !ok {
+		return fmt.Errorf("pathspec '%s' did not match any branch", name)
+	}
+	t.head = name
+	t.detached = ""
+	return nil
+}
+
+// branches lists branch names, main first and the rest alphabetically.
+func (t *GridTree) branches() []string {
+	names := make([]string, 0, len(t.refs))
+	for name := range t.refs {
+		if name != "main" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := t.refs["main"]; ok {
+		names = append([]string{"main"}, names...)
+	}
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isAncestor reports whether commit a is reachable from commit b.
+func (t *GridTree) isAncestor(a, b string) bool {
+	_, ok := t.ancestors(b)[a]
+	return ok
+}
+
+// ancestors is every commit reachable from hash, including itself.
+func (t *GridTree) ancestors(hash string) map[string]bool {
+	seen := make(map[string]bool)
+	stack := []string{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		if c, ok := t.commits[h]; ok {
+			stack = append(stack, c.parents...)
+		}
+	}
+	return seen
+}
+
+// mergeBase finds the best common ancestor of two commits, the most recent
+// commit reachable from both.
+func (t *GridTree) mergeBase(a, b string) string {
+	fromA := t.ancestors(a)
+	var best *commitNode
+	for h := range t.ancestors(b) {
+		if !fromA[h] {
+			continue
+		}
+		if c := t.commits[h]; best == nil || c.index > best.index {
+			best = c
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.hash
+}
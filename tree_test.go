@@ -0,0 +1,123 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+// testTree builds main with three commits, a side branch off the second
+// one, and a merge of it back into main:
+//
+//	first - second - third - merge  (main)
+//	             \         /
+//	              side work         (side)
+func testTree(t *testing.T) (*GridTree, map[string]string) {
+	t.Helper()
+	tree := NewGridTree()
+	grid := createGrid(0, 0, 4, 4, 160, 160, color.RGBA{})
+	hashes := make(map[string]string)
+	commit := func(name string, parents ...string) {
+		t.Helper()
+		c, err := tree.commit(grid, parents, "you", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[name] = c.hash
+	}
+	commit("first")
+	commit("second", hashes["first"])
+	if err := tree.createBranch("side"); err != nil {
+		t.Fatal(err)
+	}
+	commit("third", hashes["second"])
+	if err := tree.checkout("side"); err != nil {
+		t.Fatal(err)
+	}
+	commit("side work", hashes["second"])
+	if err := tree.checkout("main"); err != nil {
+		t.Fatal(err)
+	}
+	commit("merge", hashes["third"], hashes["side work"])
+	return &tree, hashes
+}
+
+func TestResolve(t *testing.T) {
+	tree, hashes := testTree(t)
+	tests := []struct {
+		rev  string
+		want string
+	}{
+		{"HEAD", "merge"},
+		{"@", "merge"},
+		{"main", "merge"},
+		{"side", "side work"},
+		{hashes["third"], "third"},
+		{hashes["third"][:7], "third"},
+		{"HEAD~", "third"},
+		{"HEAD~1", "third"},
+		{"HEAD~2", "second"},
+		{"main~3", "first"},
+		{"HEAD^", "third"},
+		{"HEAD^1", "third"},
+		{"HEAD^2", "side work"},
+		{"HEAD^0", "merge"},
+		{"HEAD^^", "second"},
+		{"HEAD^2~1", "second"},
+		{"HEAD^2^", "second"},
+		{"side~2", "first"},
+		{"@~1^2", ""},
+		{"HEAD~4", ""},
+		{"HEAD^3", ""},
+		{"HEAD~x", ""},
+		{"HEAD~-1", ""},
+		{"HEAD~1junk", ""},
+		{"nope", ""},
+		{"nope~1", ""},
+		{hashes["first"][:3], ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := tree.resolve(tt.rev)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("resolve(%q) = %s, want an error", tt.rev, got)
+		case tt.want != "" && err != nil:
+			t.Errorf("resolve(%q) failed: %v", tt.rev, err)
+		case tt.want != "" && got != hashes[tt.want]:
+			t.Errorf("resolve(%q) = %s, want %s (%s)", tt.rev, got, hashes[tt.want], tt.want)
+		}
+	}
+}
+
+// TestBranchDirectoryClash checks a branch can't be both a name and a
+// directory of names, which git can't store.
+func TestBranchDirectoryClash(t *testing.T) {
+	tree, _ := testTree(t)
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"side/fix", false},
+		{"side/fix/deeper", false},
+		{"sidecar", true},
+		{"topic/one", true},
+		{"topic/two", true},
+		{"topic", false},
+		{"topic/one/more", false},
+	}
+	for _, tt := range tests {
+		if err := tree.createBranch(tt.name); (err == nil) != tt.ok {
+			t.Errorf("createBranch(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	if err := tree.renameBranch("sidecar", "main/old"); err == nil {
+		t.Error("renamed a branch under main")
+	}
+	if err := tree.renameBranch("topic/one", "topic"); err == nil {
+		t.Error("renamed a branch onto the directory of another")
+	}
+	if err := tree.renameBranch("side", "side/old"); err != nil {
+		t.Errorf("a branch can't move under its own old name: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gitjits/molniya/engine"
+)
+
+// A turn goes player move, commit, enemy move. The player spends their
+// units' action points, ends the turn, and has to commit the board before
+// the enemy gets to go. The enemy then spends its own units' points, one
+// action at a time, and the next turn starts.
+//
+// Action points live on the units, so they're on the board and in every
+// commit. Checking out another branch mid-turn hands you that timeline's
+// units with whatever points they were committed with, and points only come
+// back when a new turn starts.
+
+// Action points every unit gets each turn, and what things cost
+const (
+	unitActionPoints = 2
+	moveCost         = 1
+	abilityCost      = 2
+
+	// Frames between the enemy's actions within its turn
+	botStepDelay = 20
+)
+
+type phase int
+
+const (
+	phasePlayerMove phase = iota
+	phaseCommit
+	phaseEnemyMove
+)
+
+func (p phase) String() string {
+	switch p {
+	case phaseCommit:
+		return "commit your turn"
+	case phaseEnemyMove:
+		return "enemy's move"
+	}
+	return "your move"
+}
+
+type turnState struct {
+	number int
+	phase  phase
+}
+
+func actionCost(ability bool) int {
+	if ability {
+		return abilityCost
+	}
+	return moveCost
+}
+
+// canAct says why the unit can't act right now, or "" if it can.
+func canAct(g *Game, unit Unit, ability bool) string {
+	switch {
+	case g.turn.phase == phaseCommit && !unit.BotUnit:
+		return "Your turn is over, commit it (c) to let the enemy move"
+	case g.turn.phase == phaseEnemyMove && !unit.BotUnit:
+		return "Wait for the enemy to finish its turn"
+	case g.turn.phase == phasePlayerMove && unit.BotUnit:
+		return "That's not your unit"
+	case unit.AP < actionCost(ability):
+		return fmt.Sprintf("%s has no action points left this turn", unit.Name)
+	}
+	return ""
+}
+
+// spendAP takes the cost of an action out of the unit that did it, wherever
+// it ended up.
+func spendAP(grid *TileGrid, events []engine.Event, cost int) {
+	var at engine.Pos
+	switch ev := events[0].(type) {
+	case engine.Moved:
+		at = ev.To
+	case engine.AbilityUsed:
+		at = ev.From
+	case engine.Attacked:
+		switch {
+		case ev.AttackerDied:
+			return
+		case ev.DefenderDied:
+			at = ev.To
+		default:
+			at = ev.From
+		}
+	default:
+		return
+	}
+	unit := &grid.Tiles[at.Row][at.Col].Occupant
+	unit.AP = max(0, unit.AP-cost)
+}
+
+// refillAP gives every unit of a side on the table its points back.
+func refillAP(g *Game, side engine.Side) {
+	for _, row := range g.selected.Tiles {
+		for _, tile := range row {
+			if tile.Occupant.Present && tile.Occupant.Side() == side {
+				tile.Occupant.AP = unitActionPoints
+			}
+		}
+	}
+}
+
+// sideCanAct reports whether any unit of a side has points left for a move.
+func sideCanAct(g *Game, side engine.Side) bool {
+	s := g.selected.State()
+	for _, p := range s.Units(side) {
+		if s.At(p).AP >= moveCost {
+			return true
+		}
+	}
+	return false
+}
+
+// endTurn is the player saying they're done moving.
+func endTurn(g *Game) {
+	if g.turn.phase != phasePlayerMove {
+		g.logger.AddMessage("[!] ", "It's not your move", false)
+		return
+	}
+	g.aiming = false
+	g.selected.clearSelection()
+	g.turn.phase = phaseCommit
+	g.logger.AddMessage("[!] ", fmt.Sprintf("Turn %d is over, commit it (c) to let the enemy move", g.turn.number), false)
+}
+
+func startEnemyTurn(g *Game) {
+	g.turn.phase = phaseEnemyMove
+	refillAP(g, engine.Bot)
+	g.botWaitPeriod = g.difficulty.reaction
+	g.logger.AddMessage("[!] ", fmt.Sprintf("Turn %d: the enemy's move", g.turn.number), false)
+}
+
+// endEnemyTurn hands the turn back. The enemy's moves and the player's
+// points coming back are committed first, so the player starts on a clean
+// HEAD.
+func endEnemyTurn(g *Game) {
+	refillAP(g, engine.Player)
+	if workingTreeDirty(g) {
+		// Signed by the enemy and summing up its moves, like a player commit
+		// with no message
+		message := fmt.Sprintf("end of turn %d", g.turn.number)
+		if summary := moveSummary(g.gridTree.headCommit().grid, *g.selected); summary != "" {
+			message = summary
+		}
+		enemyCommit(g, message)
+	}
+	g.turn.number++
+	g.turn.phase = phasePlayerMove
+	g.botWaitPeriod = -1
+	g.logger.AddMessage("[!] ", fmt.Sprintf("Turn %d: your move", g.turn.number), false)
+}
+
+// tickTurn moves the turn along, once a frame.
+func tickTurn(g *Game) {
+	if g.merge != nil {
+		// Nobody moves until the timelines are sorted out
+		return
+	}
+	switch g.turn.phase {
+	case phasePlayerMove:
+		if !sideCanAct(g, engine.Player) {
+			g.logger.AddMessage("[!] ", "Your units are out of action points", false)
+			endTurn(g)
+		}
+	case phaseCommit:
+		if !workingTreeDirty(g) {
+			startEnemyTurn(g)
+		}
+	case phaseEnemyMove:
+		if g.botPlan != nil {
+			finishBotMove(g)
+		} else if g.botWaitPeriod == 0 {
+			startBotMove(g)
+			g.botWaitPeriod = -1
+		} else if g.botWaitPeriod > 0 {
+			g.botWaitPeriod--
+		}
+	}
+}
+
+// botActed carries the enemy's turn on after one of its actions.
+func botActed(g *Game) {
+	if g.turn.phase != phaseEnemyMove {
+		return
+	}
+	if sideCanAct(g, engine.Bot) {
+		g.botWaitPeriod = botStepDelay
+	} else {
+		endEnemyTurn(g)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+// TestEndEnemyTurn checks the enemy's turn lands in a commit of its own and
+// the player's turn starts with nothing to commit.
+func TestEndEnemyTurn(t *testing.T) {
+	g := testGame(t)
+	g.selected.Tiles[0][0].Occupant = Unit{Name: "ours", HP: 1, StartingHP: 1, Present: true}
+	g.selected.Tiles[3][3].Occupant = Unit{Name: "theirs", HP: 1, StartingHP: 1, BotUnit: true, AP: unitActionPoints, Present: true}
+	if commitBoard(g, *g.selected, "setup", false) == nil {
+		t.Fatal("couldn't commit the setup")
+	}
+	tree := &g.gridTree
+	before := tree.headHash()
+
+	// The enemy steps one tile and ends its turn
+	g.turn = turnState{number: 1, phase: phaseEnemyMove}
+	enemy := g.selected.Tiles[3][3].Occupant
+	enemy.AP -= moveCost
+	g.selected.Tiles[3][3].Occupant = Unit{}
+	g.selected.Tiles[2][3].Occupant = enemy
+	endEnemyTurn(g)
+
+	head := tree.headCommit()
+	if head.hash == before || head.author != authorEnemy {
+		t.Fatalf("the enemy's turn wasn't committed as %s", authorEnemy)
+	}
+	if want := "theirs d4->d3"; head.message != want {
+		t.Errorf("the enemy's commit says %q, want %q", head.message, want)
+	}
+	if workingTreeDirty(g) {
+		t.Error("the player's turn starts with a dirty working tree")
+	}
+	if ap := head.grid.Tiles[0][0].Occupant.AP; ap != unitActionPoints {
+		t.Errorf("committed player unit has %d AP, want %d", ap, unitActionPoints)
+	}
+	if g.turn.number != 2 || g.turn.phase != phasePlayerMove {
+		t.Errorf("turn is %d %v, want 2 %v", g.turn.number, g.turn.phase, phasePlayerMove)
+	}
+
+	// A turn where nothing changes leaves HEAD where it was
+	g.turn.phase = phaseEnemyMove
+	after := tree.headHash()
+	endEnemyTurn(g)
+	if tree.headHash() != after {
+		t.Error("an enemy turn with no moves made a commit")
+	}
+
+	// The player's next commit only owns up to the player's moves
+	ours := g.selected.Tiles[0][0].Occupant
+	g.selected.Tiles[0][0].Occupant = Unit{}
+	g.selected.Tiles[1][0].Occupant = ours
+	gitCommitGrid(g, *g.selected, "", false, false)
+	head = tree.headCommit()
+	if head.author != authorPlayer || head.message != "ours a1->a2" {
+		t.Errorf("player commit is %q by %s, want %q by %s", head.message, head.author, "ours a1->a2", authorPlayer)
+	}
+}
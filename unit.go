@@ -2,96 +2,138 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"math/rand/v2"
-)
 
-const (
-	overwhelm_threshold float64 = 5.0
+	"github.com/gitjits/molniya/engine"
 )
 
-type Unit struct {
-	Name       string
-	MoveRange  int
-	HP         int
-	StartingHP int
-	Offense    int
-	Defense    int
-	BotUnit    bool
-
-	// This is my replacement for an Optional<Unit> type, when going through
-	// the grid we can do "if unit.Present {".
-	Present bool
-}
-
-// Player units
-var UNIT_PHONOMANCER Unit = Unit{Name: "Phonomancer", MoveRange: 2, HP: 4, StartingHP: 4, Offense: 6, Defense: 2, Present: true}
-var UNIT_NEWTHANDS Unit = Unit{Name: "Newt-Hands", MoveRange: 3, HP: 5, StartingHP: 5, Offense: 3, Defense: 2, Present: true}
-var UNIT_LBJ Unit = Unit{Name: "Lyndon B. Johnson", MoveRange: 2, HP: 8, StartingHP: 8, Offense: 3, Defense: 6, Present: true}
-
-// Enemy units
-var UNIT_WING_CENTIPEDE Unit = Unit{Name: "Tri-Winged Centipede", MoveRange: 6, HP: 3, StartingHP: 3, Offense: 4, Defense: 2, Present: true, BotUnit: true}
-var UNIT_WIZZY Unit = Unit{Name: "WIZZY", MoveRange: 10, HP: 1, StartingHP: 1, Offense: 8, Defense: 1, Present: true, BotUnit: true}
-
-func randomPopulate(grid *TileGrid) {
-    x := rand.IntN(9)
-    y := 5 + rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_LBJ
-    x = rand.IntN(9)
-    y = 5 + rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_NEWTHANDS
-    x = rand.IntN(9)
-    y = 5 + rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_PHONOMANCER
+// Units and their rules live in the engine, the game only knows how to
+// show them.
+type Unit = engine.Unit
 
-    x = rand.IntN(9)
-    y = rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_WIZZY
-    x = rand.IntN(9)
-    y = rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_WIZZY
-    x = rand.IntN(9)
-    y = rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_WING_CENTIPEDE
-    x = rand.IntN(9)
-    y = rand.IntN(3)
-    grid.Tiles[x][y].occupant = UNIT_WING_CENTIPEDE
+// randomPopulate sets up a skirmish with one of every player unit and two of
+// every enemy.
+func randomPopulate(grid *TileGrid, rng *rand.Rand) {
+	player, bot := unitsOf(engine.Player), unitsOf(engine.Bot)
+	placeArmy(grid, rng, player, len(player), grid.SizeY-4)
+	placeArmy(grid, rng, bot, 2*len(bot), 0)
 }
 
-func reportWinner(self *Unit, opp *Unit, g *Game) {
+func reportWinner(res engine.CombatResult, g *Game) {
+	self, opp := res.Attacker, res.Defender
 	var msg string
-	if self.HP > 0 {
+	switch res.Outcome() {
+	case engine.AttackerWon:
 		msg = fmt.Sprintf("%s attacked %s and won!", self.Name, opp.Name)
-	} else if opp.HP > 0 {
+	case engine.DefenderWon:
 		msg = fmt.Sprintf("%s got the jump on %s and still lost!", self.Name, opp.Name)
-	} else {
+	case engine.BothDied:
+		msg = fmt.Sprintf("%s and %s took each other down!", self.Name, opp.Name)
+	default:
 		msg = fmt.Sprintf("%s attacked %s, but they both lived!", self.Name, opp.Name)
 	}
 	g.logger.AddMessage("[!] ", msg, false)
 }
 
-func (self *Unit) attackEnemy(opp *Unit, g *Game) {
-    if self.BotUnit == opp.BotUnit && opp.Present {
-        g.logger.AddMessage("[!] ", fmt.Sprintf("friendly fire coming from %s!", self.Name), false)
-        return
-    }
-	defer reportWinner(self, opp, g)
-	var imbalance float64 = float64(self.Offense - (opp.Defense))
-	g.logger.AddMessage("[+] ", fmt.Sprintf("%s has a power imbalance of %f against %s", self.Name, imbalance, opp.Name), false)
+// logCombat writes a fight blow by blow into the log.
+func logCombat(g *Game, res engine.CombatResult) {
+	atk, def := res.Attacker, res.Defender
+	g.logger.AddMessage("[+] ", fmt.Sprintf("%s has a power imbalance of %f against %s", atk.Name, res.Imbalance, def.Name), false)
+	if res.Underdog {
+		g.logger.AddMessage("[+] ", fmt.Sprintf("rolled %d, the underdog strikes first!", res.Roll), false)
+	}
+	hit := func(from, to Unit, damage int, after Unit) {
+		if damage > 0 {
+			g.logger.AddMessage("[+] ", fmt.Sprintf("%s hits %s for %d (%d/%d HP left)", from.Name, to.Name, damage, after.HP, to.StartingHP), false)
+		}
+	}
+	if res.AttackerWonExchange {
+		hit(atk, def, res.DamageDealt, res.DefenderAfter)
+		hit(def, atk, res.DamageTaken, res.AttackerAfter)
+	} else {
+		hit(def, atk, res.DamageTaken, res.AttackerAfter)
+		hit(atk, def, res.DamageDealt, res.DefenderAfter)
+	}
+	reportWinner(res, g)
+}
+
+// logEvents writes what the engine says happened into the log.
+func logEvents(g *Game, events []engine.Event) {
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case engine.Attacked:
+			logCombat(g, ev.CombatResult)
+		case engine.FriendlyFire:
+			g.logger.AddMessage("[!] ", fmt.Sprintf("friendly fire coming from %s!", ev.Unit.Name), false)
+		case engine.Rejected:
+			g.logger.AddMessage("[!] ", ev.Reason, false)
+		case engine.AbilityUsed:
+			g.logger.AddMessage("[+] ", fmt.Sprintf("%s uses %s on %s!", ev.Unit.Name, ev.Ability.Name, cellName(fromPos(ev.Target))), false)
+		case engine.Damaged:
+			if ev.Died {
+				g.logger.AddMessage("[+] ", fmt.Sprintf("%s takes %d and goes down!", ev.Unit.Name, ev.Damage), false)
+			} else {
+				g.logger.AddMessage("[+] ", fmt.Sprintf("%s takes %d (%d/%d HP left)", ev.Unit.Name, ev.Damage, ev.Unit.HP, ev.Unit.StartingHP), false)
+			}
+		case engine.Healed:
+			g.logger.AddMessage("[+] ", fmt.Sprintf("%s heals %d (%d/%d HP)", ev.Unit.Name, ev.Amount, ev.Unit.HP, ev.Unit.StartingHP), false)
+		}
+	}
+}
 
-	lost := math.Signbit(imbalance)
+// abilityText is the line about a unit's ability in the info panel.
+func abilityText(u Unit) string {
+	ability, ok := engine.LookupAbility(u.Ability)
+	if !ok {
+		return ""
+	}
+	switch {
+	case ability.Targeting == engine.Passive:
+		return "\n\t" + ability.Name
+	case u.Cooldown > 0:
+		return fmt.Sprintf("\n\t%s (%d)", ability.Name, u.Cooldown)
+	}
+	return "\n\t" + ability.Name + " (a)"
+}
 
-	// 20% chance of an underdog win, unless the difference is massive
-	random := rand.IntN(100)
-	if random > 80 && math.Abs(imbalance) < overwhelm_threshold {
-		lost = !lost
+// fightSummary is one line on how a fight would end.
+func fightSummary(res engine.CombatResult) string {
+	switch res.Outcome() {
+	case engine.AttackerWon:
+		return fmt.Sprintf("deal %d, %s dies", res.DamageDealt, res.Defender.Name)
+	case engine.DefenderWon:
+		return fmt.Sprintf("take %d, %s dies", res.DamageTaken, res.Attacker.Name)
+	case engine.BothDied:
+		return "both die"
 	}
+	return fmt.Sprintf("deal %d, take %d", res.DamageDealt, res.DamageTaken)
+}
 
-	if lost {
-		// Attacker lost...
-		self.HP = 0
-	} else {
-		// Attacker overcame odds!
-		opp.HP = 0
+// matchupText sizes up the attack the player is lining up, the unit picked
+// with the first click against the enemy under the cursor.
+func matchupText(g *Game) string {
+	grid := g.selected
+	if grid == nil || g.merge != nil || g.stop || g.aiming {
+		return ""
+	}
+	from, to := grid.selectedCells[0], g.hovered
+	if !from.valid || !to.valid || to.x >= len(grid.Tiles) || to.y >= len(grid.Tiles[to.x]) {
+		return ""
+	}
+	s := grid.State()
+	atk, def := s.Inspired(toPos(from)), s.Inspired(toPos(to))
+	if !atk.Present || !def.Present || atk.BotUnit == def.BotUnit {
+		return ""
+	}
+	p := engine.Predict(atk, def)
+	rule := fmt.Sprintf("underdog rule: %.0f%% upset", engine.UnderdogChance*100)
+	if p.Overwhelming {
+		rule = "overwhelming, no upsets"
+	}
+	text := fmt.Sprintf("%s vs %s\n imbalance: %+.0f (%d off - %d def)\n %s\n win chance: %.0f%%\n if won: %s\n if lost: %s",
+		atk.Name, def.Name, p.Imbalance, atk.Offense, def.Defense, rule, p.WinChance*100, fightSummary(p.IfWon), fightSummary(p.IfLost))
+	if _, ok := s.Reachable(toPos(from)).Attacks[toPos(to)]; !ok {
+		text += "\n out of range!"
 	}
+	return text
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/gitjits/molniya/engine"
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// The unit roster and its sprites ship inside the binary. A designer can
+// point -units at their own file to add or rebalance units without
+// rebuilding. Only the sprites units.json uses are embedded, the README
+// screenshots and spare art stay out of the binary.
+//
+//go:embed units.json lbj.png newt.png phonomancer.png wizzy.png centepede.png
+var assets embed.FS
+
+const defaultUnitsFile = "units.json"
+
+// units.json lists the factions and then every kind of unit:
+//
+//	{
+//	  "factions": [
+//	    {
+//	      "name":        string,
+//	      "side":        "player"|"bot",
+//	      "personality": string  // how the bot plays it, optional
+//	    }, ...
+//	  ],
+//	  "units": [
+//	    {
+//	      "name":       string,  // shown in the UI, unique
+//	      "sprite":     string,  // png next to the file, or one we ship
+//	      "faction":    string,
+//	      "move_range": int,
+//	      "hp":         int,
+//	      "offense":    int,
+//	      "defense":    int,
+//	      "ability":    string   // optional
+//	    }, ...
+//	  ]
+//	}
+type unitFile struct {
+	Factions []factionDef `json:"factions"`
+	Units    []unitDef    `json:"units"`
+}
+
+type factionDef struct {
+	Name        string `json:"name"`
+	Side        string `json:"side"`
+	Personality string `json:"personality"`
+}
+
+type unitDef struct {
+	Name      string `json:"name"`
+	Sprite    string `json:"sprite"`
+	Faction   string `json:"faction"`
+	MoveRange int    `json:"move_range"`
+	HP        int    `json:"hp"`
+	Offense   int    `json:"offense"`
+	Defense   int    `json:"defense"`
+	Ability   string `json:"ability"`
+}
+
+// unitKinds is the roster in the order the file lists it, unitSprites what
+// each kind is drawn with, by name, and factionPersonalities how the bot
+// plays each faction.
+var (
+	unitKinds            []Unit
+	factionPersonalities map[string]engine.Personality
+	unitImages           = make(map[string]image.Image)
+	unitSprites          = make(map[string]*ebiten.Image)
+)
+
+// loadUnitDefs reads and validates a unit file, the embedded one when path
+// is empty, and makes its units the ones the game is played with.
+func loadUnitDefs(path string) error {
+	var data []byte
+	var err error
+	var sprites fs.FS = assets
+	if path == "" {
+		data, err = assets.ReadFile(defaultUnitsFile)
+		path = defaultUnitsFile
+	} else {
+		data, err = os.ReadFile(path)
+		sprites = layeredFS{os.DirFS(filepath.Dir(path)), assets}
+	}
+	if err != nil {
+		return err
+	}
+	var file unitFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	kinds, images, err := file.build(sprites)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	unitKinds, unitImages = kinds, images
+	factionPersonalities = make(map[string]engine.Personality)
+	for _, fac := range file.Factions {
+		if p, ok := engine.LookupPersonality(fac.Personality); ok {
+			factionPersonalities[fac.Name] = p
+		}
+	}
+	return nil
+}
+
+// build checks every definition and turns it into a unit, collecting every
+// problem rather than stopping at the first.
+func (f *unitFile) build(sprites fs.FS) ([]Unit, map[string]image.Image, error) {
+	var errs []error
+	sides := make(map[string]engine.Side)
+	for _, fac := range f.Factions {
+		switch {
+		case fac.Name == "":
+			errs = append(errs, errors.New("faction without a name"))
+		case fac.Side != engine.Player.String() && fac.Side != engine.Bot.String():
+			errs = append(errs, fmt.Errorf("faction %s: side must be %q or %q, not %q", fac.Name, engine.Player, engine.Bot, fac.Side))
+		default:
+			if _, dup := sides[fac.Name]; dup {
+				errs = append(errs, fmt.Errorf("faction %s is defined twice", fac.Name))
+			}
+			sides[fac.Name] = engine.Player
+			if fac.Side == engine.Bot.String() {
+				sides[fac.Name] = engine.Bot
+			}
+			if _, ok := engine.LookupPersonality(fac.Personality); fac.Personality != "" && !ok {
+				errs = append(errs, fmt.Errorf("faction %s: unknown personality %q", fac.Name, fac.Personality))
+			}
+		}
+	}
+
+	var kinds []Unit
+	images := make(map[string]image.Image)
+	seen := make(map[engine.Side]bool)
+	for k, def := range f.Units {
+		if def.Name == "" {
+			errs = append(errs, fmt.Errorf("unit %d has no name", k+1))
+			continue
+		}
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("unit %s: "+format, append([]any{def.Name}, args...)...))
+		}
+		if _, dup := images[def.Name]; dup {
+			fail("is defined twice")
+		}
+		side, ok := sides[def.Faction]
+		if !ok {
+			fail("unknown faction %q", def.Faction)
+		}
+		if def.MoveRange < 1 {
+			fail("move_range must be at least 1")
+		}
+		if def.HP < 1 {
+			fail("hp must be at least 1")
+		}
+		if def.Offense < 0 || def.Defense < 0 {
+			fail("offense and defense can't be negative")
+		}
+		if _, ok := engine.LookupAbility(def.Ability); def.Ability != "" && !ok {
+			fail("unknown ability %q", def.Ability)
+		}
+		img, err := readSprite(sprites, def.Sprite)
+		if err != nil {
+			fail("sprite: %v", err)
+		}
+		images[def.Name] = img
+		seen[side] = true
+		kinds = append(kinds, Unit{
+			Name:       def.Name,
+			MoveRange:  def.MoveRange,
+			HP:         def.HP,
+			StartingHP: def.HP,
+			Offense:    def.Offense,
+			Defense:    def.Defense,
+			BotUnit:    side == engine.Bot,
+			Faction:    def.Faction,
+			Ability:    def.Ability,
+			AP:         unitActionPoints,
+			Present:    true,
+		})
+	}
+	if len(errs) == 0 && (!seen[engine.Player] || !seen[engine.Bot]) {
+		errs = append(errs, errors.New("both sides need at least one unit"))
+	}
+	return kinds, images, errors.Join(errs...)
+}
+
+func readSprite(sprites fs.FS, name string) (image.Image, error) {
+	if name == "" {
+		return nil, errors.New("missing")
+	}
+	data, err := fs.ReadFile(sprites, name)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return img, nil
+}
+
+// layeredFS looks a file up in each file system in turn, so a unit file can
+// bring its own sprites and still use the ones we ship.
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, fsys := range l[:len(l)-1] {
+		if f, err := fsys.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return l[len(l)-1].Open(name)
+}
+
+// forcePersonality makes every faction play with the same personality.
+func forcePersonality(name string) error {
+	p, ok := engine.LookupPersonality(name)
+	if !ok {
+		return fmt.Errorf("unknown personality %q", name)
+	}
+	for _, u := range unitKinds {
+		factionPersonalities[u.Faction] = p
+	}
+	return nil
+}
+
+// unitsOf returns every kind of unit that fights for side.
+func unitsOf(side engine.Side) []Unit {
+	var out []Unit
+	for _, u := range unitKinds {
+		if u.Side() == side {
+			out = append(out, u)
+		}
+	}
+	return out
+}